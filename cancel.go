@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"github.com/go-kit/log/level"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// A context canceled on the first SIGINT or SIGTERM. The migration then stops
+// reading whisper files, finishes the TSM file or batch being written and
+// prints a partial summary. A second signal exits at once
+func CancelOnSignal() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		level.Warn(logger).Log("msg", "Stopping the migration, send the signal again to exit now",
+			"signal", sig)
+		cancel()
+		<-signals
+		os.Exit(1)
+	}()
+	return ctx
+}
+
+// The context of the migration, canceled on a signal
+func (migrationData *MigrationData) Context() context.Context {
+	if migrationData.ctx == nil {
+		return context.Background()
+	}
+	return migrationData.ctx
+}
+
+// The error the migration was canceled with, nil while it runs
+func (migrationData *MigrationData) Canceled() error {
+	if migrationData.ctx == nil {
+		return nil
+	}
+	return migrationData.ctx.Err()
+}
+
+// A whisper file of size bytes has been read
+func (migrationData *MigrationData) FileRead(size int64) {
+	migrationData.filesRead = migrationData.filesRead + 1
+	migrationData.progress.FileDone(size)
+	filesProcessed.Inc()
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/go-kit/log/level"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// A Ceres tree stores every metric as a node directory holding a .ceres-node
+// metadata file and slice files named <startTime>@<timeStep>.slice. A slice is
+// a run of big endian float64 values, one per timeStep from startTime, with
+// NaN for missing values
+const ceresNodeFile = ".ceres-node"
+
+const ceresValueSize = 8
+
+var ceresSliceName = regexp.MustCompile(`^(\d+)@(\d+)\.slice$`)
+
+type CeresNodeMetadata struct {
+	TimeStep uint32 `json:"timeStep"`
+}
+
+type CeresSlice struct {
+	path      string
+	startTime uint32
+	timeStep  uint32
+	points    int64
+	modTime   time.Time
+}
+
+func (slice CeresSlice) endTime() int64 {
+	return int64(slice.startTime) + slice.points*int64(slice.timeStep)
+}
+
+func IsCeresNodeFile(name string) bool {
+	return name == ceresNodeFile
+}
+
+// Find all Ceres nodes under searchDir which pass the include/exclude and
+// mtime filters. The nodes take the place of whisper files in wspFiles
+func (migrationData *MigrationData) FindCeresNodes(searchDir string) {
+	filter := migrationData.filter
+	if filter == nil {
+		filter = &FileFilter{}
+	}
+	found := func(path string, f os.FileInfo) {
+		node := filepath.Dir(path)
+		if !filter.MatchName(MetricName(searchDir, node)) {
+			return
+		}
+		slices, err := ReadCeresSlices(node)
+		if err != nil {
+			level.Warn(logger).Log("msg", "Could not read ceres node", "phase", "scan",
+				"file", node, "err", err)
+			return
+		}
+		var size int64
+		var modTime time.Time
+		for _, slice := range slices {
+			size = size + slice.points*ceresValueSize
+			if slice.modTime.After(modTime) {
+				modTime = slice.modTime
+			}
+		}
+		if !filter.MatchMtime(modTime) {
+			return
+		}
+		migrationData.AddWhisperFile(node, size)
+	}
+	if err := filter.walk(searchDir, map[string]bool{}, IsCeresNodeFile,
+		found); err != nil && !os.IsNotExist(err) {
+		level.Error(logger).Log("msg", "Could not read ceres folder", "phase", "scan",
+			"err", err)
+	}
+}
+
+// List the slices of a node
+func ReadCeresSlices(node string) ([]CeresSlice, error) {
+	entries, err := ioutil.ReadDir(node)
+	if err != nil {
+		return nil, err
+	}
+	var slices []CeresSlice
+	for _, entry := range entries {
+		match := ceresSliceName.FindStringSubmatch(entry.Name())
+		if match == nil || entry.IsDir() {
+			continue
+		}
+		var slice CeresSlice
+		fmt.Sscan(match[1], &slice.startTime)
+		fmt.Sscan(match[2], &slice.timeStep)
+		if slice.timeStep == 0 {
+			return nil, fmt.Errorf("slice %s has a zero time step", entry.Name())
+		}
+		slice.path = filepath.Join(node, entry.Name())
+		//a partially written trailing value is ignored
+		slice.points = entry.Size() / ceresValueSize
+		slice.modTime = entry.ModTime()
+		slices = append(slices, slice)
+	}
+	return slices, nil
+}
+
+// Check the node metadata and slices, the returned error is the reason the
+// node can not be migrated
+func ValidateCeresNode(node string) (*CeresNodeMetadata, error) {
+	raw, err := ioutil.ReadFile(filepath.Join(node, ceresNodeFile))
+	if err != nil {
+		return nil, err
+	}
+	var metadata CeresNodeMetadata
+	if err := json.Unmarshal(raw, &metadata); err != nil {
+		return nil, fmt.Errorf("invalid node metadata: %s", err)
+	}
+	if metadata.TimeStep == 0 {
+		return nil, fmt.Errorf("node metadata has no timeStep")
+	}
+	_, err = ReadCeresSlices(node)
+	return &metadata, err
+}
+
+func (migrationData *MigrationData) ValidateCeresNodes() {
+	for _, node := range migrationData.wspFiles {
+		metadata, err := ValidateCeresNode(node)
+		if err != nil {
+			migrationData.Quarantine(node, err)
+			continue
+		}
+		migrationData.resolutions[node] = metadata.TimeStep
+	}
+}
+
+// Merge the slices of a node into one ordered series for the given time
+// range. Where slices overlap the finest time step wins, and for equal time
+// steps the most recently started slice. As for whisper, the points after
+// from up to until are returned
+func FetchCeresPoints(node string, from time.Time, until time.Time) ([]whisper.Point, error) {
+	slices, err := ReadCeresSlices(node)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(slices, func(i, j int) bool {
+		if slices[i].timeStep != slices[j].timeStep {
+			return slices[i].timeStep < slices[j].timeStep
+		}
+		return slices[i].startTime > slices[j].startTime
+	})
+
+	fromTime, untilTime := from.Unix(), until.Unix()
+	var points []whisper.Point
+	var covered []CeresSlice
+	for _, slice := range slices {
+		step := int64(slice.timeStep)
+		first := int64(0)
+		if fromTime >= int64(slice.startTime) {
+			first = (fromTime-int64(slice.startTime))/step + 1
+		}
+		last := slice.points - 1
+		if untilTime < slice.endTime() {
+			last = (untilTime - int64(slice.startTime)) / step
+		}
+		if first > last {
+			covered = append(covered, slice)
+			continue
+		}
+		values, err := readCeresValues(slice, first, last)
+		if err != nil {
+			return nil, err
+		}
+		for i, value := range values {
+			timestamp := int64(slice.startTime) + (first+int64(i))*step
+			if math.IsNaN(value) || isCovered(covered, timestamp) {
+				continue
+			}
+			points = append(points, whisper.Point{Timestamp: uint32(timestamp), Value: value})
+		}
+		covered = append(covered, slice)
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Timestamp < points[j].Timestamp
+	})
+	return points, nil
+}
+
+func isCovered(slices []CeresSlice, timestamp int64) bool {
+	for _, slice := range slices {
+		if timestamp >= int64(slice.startTime) && timestamp < slice.endTime() {
+			return true
+		}
+	}
+	return false
+}
+
+// Read values first to last, both inclusive, of a slice
+func readCeresValues(slice CeresSlice, first int64, last int64) ([]float64, error) {
+	f, err := os.Open(slice.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, (last-first+1)*ceresValueSize)
+	if _, err := f.ReadAt(buf, first*ceresValueSize); err != nil {
+		return nil, fmt.Errorf("truncated slice %s: %s", slice.path, err)
+	}
+	values := make([]float64, last-first+1)
+	for i := range values {
+		values[i] = math.Float64frombits(binary.BigEndian.Uint64(buf[i*ceresValueSize:]))
+	}
+	return values, nil
+}
+
+// Timestamp of the newest non-null value of a node, 0 if it has none
+func CeresLastUpdate(node string) (uint32, error) {
+	slices, err := ReadCeresSlices(node)
+	if err != nil {
+		return 0, err
+	}
+	var last int64
+	for _, slice := range slices {
+		if slice.points == 0 || slice.endTime() <= last {
+			continue
+		}
+		values, err := readCeresValues(slice, 0, slice.points-1)
+		if err != nil {
+			return 0, err
+		}
+		for i := len(values) - 1; i >= 0; i-- {
+			if math.IsNaN(values[i]) {
+				continue
+			}
+			if timestamp := int64(slice.startTime) + int64(i)*int64(slice.timeStep); timestamp > last {
+				last = timestamp
+			}
+			break
+		}
+	}
+	return uint32(last), nil
+}
+
+// Same as GetWhisperInfo for Ceres nodes
+func (migrationData *MigrationData) GetCeresInfo() error {
+	for _, node := range migrationData.wspFiles {
+		pts, err := FetchCeresPoints(node, time.Unix(0, 0), time.Now())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Ceres Node : %s\n", node)
+		if len(pts) > 0 {
+			fmt.Println("Oldest Data in Node : ", time.Unix(int64(pts[0].Timestamp), 0))
+		}
+		fmt.Println("Number of ceres points : ", len(pts))
+		fmt.Println("-----------------------------------------------------------------------")
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCeresSlice(t *testing.T, node string, name string, values ...float64) {
+	buf := make([]byte, len(values)*ceresValueSize)
+	for i, value := range values {
+		binary.BigEndian.PutUint64(buf[i*ceresValueSize:], math.Float64bits(value))
+	}
+	if err := ioutil.WriteFile(filepath.Join(node, name), buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFetchCeresPoints(t *testing.T) {
+	node := t.TempDir()
+	writeCeresSlice(t, node, "600@60.slice", 1, 2, math.NaN(), 4, 5)
+	//finer slice overlapping the last two minutes
+	writeCeresSlice(t, node, "780@30.slice", 40, 41, 50)
+
+	tests := []struct {
+		from  int64
+		until int64
+		want  []uint32
+	}{
+		{0, 2000, []uint32{600, 660, 780, 810, 840}},
+		//as for whisper the point at from is left out, the one at until kept
+		{600, 780, []uint32{660, 780}},
+		{659, 660, []uint32{660}},
+		{840, 2000, nil},
+	}
+	for _, test := range tests {
+		points, err := FetchCeresPoints(node, time.Unix(test.from, 0), time.Unix(test.until, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []uint32
+		for _, point := range points {
+			got = append(got, point.Timestamp)
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%d-%d: got %v, want %v", test.from, test.until, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%d-%d: got %v, want %v", test.from, test.until, got, test.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+)
+
+// Writes through the InfluxDB 1.x HTTP API in batches, the Sink used by the
+// ClientV2 option in sync mode
+type ClientV2Sink struct {
+	client    client.Client
+	dbName    string
+	batchSize int
+	bp        client.BatchPoints
+}
+
+func (migrationData *MigrationData) NewClientV2Sink() (*ClientV2Sink, error) {
+	c, err := client.NewHTTPClient(client.HTTPConfig{
+		Addr:     migrationData.host + ":" + migrationData.port,
+		Username: migrationData.username,
+		Password: migrationData.password,
+	})
+	if err != nil {
+		return nil, err
+	}
+	createDBString := fmt.Sprintf("Create Database %v", migrationData.dbName)
+	if _, err := c.Query(client.NewQuery(createDBString, "", "")); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return &ClientV2Sink{client: c, dbName: migrationData.dbName,
+		batchSize: migrationData.batchSize}, nil
+}
+
+func (sink *ClientV2Sink) WriteSeries(mtf *MTF, wspPoints []whisper.Point) error {
+	pts, err := NewSeriesPoints(mtf, wspPoints)
+	if err != nil {
+		return err
+	}
+	for _, pt := range pts {
+		if sink.bp == nil {
+			sink.bp, _ = client.NewBatchPoints(client.BatchPointsConfig{
+				Database:  sink.dbName,
+				Precision: "s",
+			})
+		}
+		sink.bp.AddPoint(pt)
+		if len(sink.bp.Points()) >= sink.batchSize {
+			if err := sink.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (sink *ClientV2Sink) flush() error {
+	if sink.bp == nil {
+		return nil
+	}
+	if err := sink.client.Write(sink.bp); err != nil {
+		return fmt.Errorf("Error in writing to InfluxDB : %s", err)
+	}
+	sink.bp = nil
+	return nil
+}
+
+func (sink *ClientV2Sink) Close() error {
+	err := sink.flush()
+	if closeErr := sink.client.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
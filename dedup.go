@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-kit/log/level"
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/influxdata/influxdb/influxql"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Data of a series already in InfluxDB, seconds since the epoch
+type ExistingSeries struct {
+	earliest   uint32
+	timestamps map[uint32]bool
+}
+
+// Find, before anything is written, the data InfluxDB already holds for the
+// series to migrate. The TSMW option reads the TSM files of the database
+// under influxDataDir and the WAL in the wal folder next to it, the ClientV2
+// option queries InfluxDB. With -dedup=
+// truncate only the earliest timestamp of every series is needed, with
+// -dedup=target every timestamp in the time range of the migration
+func (migrationData *MigrationData) LoadExistingSeries() error {
+	series := make(map[string]*MTF)
+	for _, wspFile := range migrationData.wspFiles {
+		mtf := migrationData.LookupMTF(wspFile)
+		series[CreateTSMKey(mtf)] = mtf
+	}
+	migrationData.existing = make(map[string]*ExistingSeries)
+	if migrationData.option == "TSMW" {
+		if err := migrationData.readExistingTSM(series); err != nil {
+			return err
+		}
+		return migrationData.readExistingWAL(series)
+	}
+	return migrationData.queryExistingSeries(series)
+}
+
+func (migrationData *MigrationData) readExistingTSM(series map[string]*MTF) error {
+	files, err := filepath.Glob(filepath.Join(migrationData.influxDataDir,
+		migrationData.dbName, "*", "*", "*.tsm"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		r, err := tsm1.NewTSMReader(f)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("%s: %s", file, err)
+		}
+		for key := range series {
+			if !r.Contains(key) {
+				continue
+			}
+			existing := migrationData.existingSeries(key)
+			if migrationData.dedup == "truncate" {
+				for _, entry := range r.Entries(key) {
+					existing.add(entry.MinTime, false)
+				}
+				continue
+			}
+			values, err := r.ReadAll(key)
+			if err != nil {
+				r.Close()
+				return fmt.Errorf("%s: %s", file, err)
+			}
+			for _, value := range values {
+				existing.add(value.UnixNano(), true)
+			}
+		}
+		//closed before the migration writes TSM files, which may be this one
+		r.Close()
+	}
+	return nil
+}
+
+// Points written to InfluxDB but not yet compacted into TSM files are in the
+// WAL segments, e.g. /var/lib/influxdb/wal/<db>/<rp>/<shard>/_00001.wal for
+// the data dir /var/lib/influxdb/data. Deletes in the WAL are not applied, a
+// deleted point still counts as existing
+func (migrationData *MigrationData) readExistingWAL(series map[string]*MTF) error {
+	walDir := filepath.Join(filepath.Dir(filepath.Clean(migrationData.influxDataDir)), "wal")
+	files, err := filepath.Glob(filepath.Join(walDir, migrationData.dbName,
+		"*", "*", "_*.wal"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		r := tsm1.NewWALSegmentReader(f)
+		for r.Next() {
+			entry, err := r.Read()
+			if err != nil {
+				//the last segment may end in a partly written entry
+				level.Warn(logger).Log("msg", "Could not read the rest of the WAL segment",
+					"phase", "scan", "file", file, "err", err)
+				break
+			}
+			write, ok := entry.(*tsm1.WriteWALEntry)
+			if !ok {
+				continue
+			}
+			for key, values := range write.Values {
+				if series[key] == nil {
+					continue
+				}
+				existing := migrationData.existingSeries(key)
+				for _, value := range values {
+					existing.add(value.UnixNano(), migrationData.dedup != "truncate")
+				}
+			}
+		}
+		r.Close()
+	}
+	return nil
+}
+
+func (migrationData *MigrationData) queryExistingSeries(series map[string]*MTF) error {
+	c, err := client.NewHTTPClient(client.HTTPConfig{
+		Addr:     migrationData.host + ":" + migrationData.port,
+		Username: migrationData.username,
+		Password: migrationData.password,
+	})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	from := migrationData.from.Add(migrationData.timeShift)
+	until := migrationData.until.Add(migrationData.timeShift)
+	for key, mtf := range series {
+		var where []string
+		for _, tag := range mtf.Tags {
+			where = append(where, influxql.QuoteIdent(tag.Tagkey)+" = "+
+				influxql.QuoteString(tag.Tagvalue))
+		}
+		selector := influxql.QuoteIdent(mtf.Field)
+		if migrationData.dedup == "truncate" {
+			selector = "first(" + selector + ")"
+		} else {
+			where = append(where, fmt.Sprintf("time >= %ds AND time <= %ds",
+				from.Unix(), until.Unix()))
+		}
+		command := fmt.Sprintf("SELECT %s FROM %s", selector,
+			influxql.QuoteIdent(mtf.Measurement))
+		if len(where) > 0 {
+			command = command + " WHERE " + strings.Join(where, " AND ")
+		}
+		//the WHERE clause also matches series with more tags, grouping by
+		//all tags tells them apart
+		command = command + " GROUP BY *"
+		response, err := c.Query(client.NewQuery(command, migrationData.dbName, "s"))
+		if err == nil {
+			err = response.Error()
+		}
+		if err != nil {
+			return fmt.Errorf("Error in Querying %s : %s", key, err)
+		}
+		for _, result := range response.Results {
+			for _, row := range result.Series {
+				if !SameTags(mtf.Tags, row.Tags) {
+					continue
+				}
+				for _, values := range row.Values {
+					timestamp, err := values[0].(json.Number).Int64()
+					if err != nil {
+						return err
+					}
+					migrationData.existingSeries(key).add(
+						time.Unix(timestamp, 0).UnixNano(), migrationData.dedup != "truncate")
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Whether the tags of a series, leaving out empty values, are exactly the tags
+// of a row returned by InfluxDB
+func SameTags(tags []TagKeyValue, rowTags map[string]string) bool {
+	n := 0
+	for _, tag := range tags {
+		if tag.Tagvalue == "" {
+			continue
+		}
+		if rowTags[tag.Tagkey] != tag.Tagvalue {
+			return false
+		}
+		n++
+	}
+	for _, value := range rowTags {
+		if value != "" {
+			n--
+		}
+	}
+	return n == 0
+}
+
+func (migrationData *MigrationData) existingSeries(key string) *ExistingSeries {
+	existing := migrationData.existing[key]
+	if existing == nil {
+		existing = &ExistingSeries{timestamps: make(map[uint32]bool)}
+		migrationData.existing[key] = existing
+	}
+	return existing
+}
+
+func (existing *ExistingSeries) add(unixNano int64, keep bool) {
+	timestamp := uint32(time.Unix(0, unixNano).Unix())
+	if existing.earliest == 0 || timestamp < existing.earliest {
+		existing.earliest = timestamp
+	}
+	if keep {
+		existing.timestamps[timestamp] = true
+	}
+}
+
+// Leave out the points of a series which conflict with the data already in
+// InfluxDB: with -dedup=truncate every point from the earliest existing one
+// on, with -dedup=target the points at an existing timestamp
+func (migrationData *MigrationData) DedupPoints(mtf *MTF,
+	wspPoints []whisper.Point) []whisper.Point {
+
+	existing := migrationData.existing[CreateTSMKey(mtf)]
+	if existing == nil {
+		return wspPoints
+	}
+	points := make([]whisper.Point, 0, len(wspPoints))
+	for _, wspPoint := range wspPoints {
+		if migrationData.dedup == "truncate" && wspPoint.Timestamp >= existing.earliest {
+			continue
+		}
+		if migrationData.dedup == "target" && existing.timestamps[wspPoint.Timestamp] {
+			continue
+		}
+		points = append(points, wspPoint)
+	}
+	migrationData.dedupedPoints = migrationData.dedupedPoints + len(wspPoints) - len(points)
+	pointsDropped.WithLabelValues("dedup").Add(float64(len(wspPoints) - len(points)))
+	return points
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+)
+
+func TestSameTags(t *testing.T) {
+	tests := []struct {
+		tags    []TagKeyValue
+		rowTags map[string]string
+		want    bool
+	}{
+		{nil, map[string]string{}, true},
+		{nil, map[string]string{"host": ""}, true},
+		{nil, map[string]string{"host": "a"}, false},
+		{[]TagKeyValue{{"host", ""}}, map[string]string{"host": ""}, true},
+		{[]TagKeyValue{{"host", "a"}}, map[string]string{"host": "a"}, true},
+		{[]TagKeyValue{{"host", "a"}}, map[string]string{"host": "a", "dc": "x"}, false},
+		{[]TagKeyValue{{"host", "a"}, {"dc", "x"}}, map[string]string{"host": "a"}, false},
+		{[]TagKeyValue{{"host", "a"}}, map[string]string{"host": "b"}, false},
+	}
+	for _, test := range tests {
+		if got := SameTags(test.tags, test.rowTags); got != test.want {
+			t.Errorf("SameTags(%v, %v) = %v, want %v", test.tags, test.rowTags, got, test.want)
+		}
+	}
+}
+
+func TestReadExistingWAL(t *testing.T) {
+	root := t.TempDir()
+	shardDir := filepath.Join(root, "wal", "graphite", "autogen", "1")
+	if err := os.MkdirAll(shardDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(shardDir, "_00001.wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtf := &MTF{Measurement: "cpu", Field: "value", Tags: []TagKeyValue{{"host", "a"}}}
+	entry := &tsm1.WriteWALEntry{Values: map[string][]tsm1.Value{
+		CreateTSMKey(mtf): {tsm1.NewValue(time.Unix(120, 0).UnixNano(), 1.0),
+			tsm1.NewValue(time.Unix(60, 0).UnixNano(), 2.0)},
+		"mem#!~#value": {tsm1.NewValue(time.Unix(30, 0).UnixNano(), 1.0)},
+	}}
+	encoded, err := entry.Encode(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tsm1.NewWALSegmentWriter(f).Write(entry.Type(), snappy.Encode(nil, encoded)); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	migrationData := &MigrationData{influxDataDir: filepath.Join(root, "data"),
+		dbName: "graphite", dedup: "target", existing: make(map[string]*ExistingSeries)}
+	if err := migrationData.readExistingWAL(map[string]*MTF{CreateTSMKey(mtf): mtf}); err != nil {
+		t.Fatal(err)
+	}
+	if len(migrationData.existing) != 1 {
+		t.Fatalf("existing %v", migrationData.existing)
+	}
+	points := migrationData.DedupPoints(mtf, []whisper.Point{{Timestamp: 60, Value: 1},
+		{Timestamp: 90, Value: 1}, {Timestamp: 120, Value: 1}})
+	if len(points) != 1 || points[0].Timestamp != 90 {
+		t.Errorf("points %v", points)
+	}
+}
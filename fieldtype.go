@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-kit/log/level"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+	"math"
+)
+
+// Field types a tag config may give in "type", float is the default
+func IsValidFieldType(fieldType string) bool {
+	switch fieldType {
+	case "", "float", "integer", "unsigned", "boolean":
+		return true
+	}
+	return false
+}
+
+// Convert a whisper value to the field type, a float64, int64, uint64 or bool.
+// Whisper stores every value as a float64, a value which does not convert
+// without loss, e.g. 1.5 to integer, -1 to unsigned or 2 to boolean, is an
+// error
+func ConvertValue(fieldType string, value float64) (interface{}, error) {
+	switch fieldType {
+	case "", "float":
+		return value, nil
+	case "boolean":
+		if value != 0 && value != 1 {
+			return nil, fmt.Errorf("%v is not a boolean, only 0 and 1 are", value)
+		}
+		return value == 1, nil
+	}
+	if value != math.Trunc(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("%v is not an %s", value, fieldType)
+	}
+	if fieldType == "unsigned" {
+		if value < 0 {
+			return nil, fmt.Errorf("%v is not an unsigned", value)
+		}
+		//2^64 itself does not fit an uint64
+		if value >= math.MaxUint64 {
+			return nil, fmt.Errorf("%v overflows an unsigned", value)
+		}
+		return uint64(value), nil
+	}
+	//2^63 itself does not fit an int64
+	if value < math.MinInt64 || value >= math.MaxInt64 {
+		return nil, fmt.Errorf("%v overflows an %s", value, fieldType)
+	}
+	return int64(value), nil
+}
+
+// Convert a whisper value to the field type as it is written to InfluxDB. The
+// TSM writer and the client of InfluxDB 0.13 have no unsigned field type, the
+// client would write an uint64 as a string, so unsigned values are written as
+// integers and only those up to MaxInt64 can be written
+func InfluxDBValue(fieldType string, value float64) (interface{}, error) {
+	converted, err := ConvertValue(fieldType, value)
+	if unsigned, ok := converted.(uint64); ok {
+		if unsigned > math.MaxInt64 {
+			return nil, fmt.Errorf("%d overflows the integer an unsigned is written as",
+				unsigned)
+		}
+		return int64(unsigned), nil
+	}
+	return converted, err
+}
+
+// Leave out the points of a series which do not convert to the field type of
+// its tag config, the points left out are counted in rejectedPoints
+func (migrationData *MigrationData) RejectLossyPoints(wspFile string, mtf *MTF,
+	wspPoints []whisper.Point) []whisper.Point {
+
+	if mtf.Type == "" || mtf.Type == "float" {
+		return wspPoints
+	}
+	var lastErr error
+	points := make([]whisper.Point, 0, len(wspPoints))
+	for _, wspPoint := range wspPoints {
+		if _, err := InfluxDBValue(mtf.Type, wspPoint.Value); err != nil {
+			lastErr = err
+			continue
+		}
+		points = append(points, wspPoint)
+	}
+	if rejected := len(wspPoints) - len(points); rejected > 0 {
+		level.Warn(logger).Log("msg", "Skipping points not convertible to the type",
+			"phase", "map", "file", wspFile, "series", CreateTSMKey(mtf),
+			"type", mtf.Type, "count", rejected, "err", lastErr)
+		migrationData.rejectedPoints = migrationData.rejectedPoints + rejected
+		pointsDropped.WithLabelValues("type").Add(float64(rejected))
+	}
+	return points
+}
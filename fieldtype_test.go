@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/uttamgandhi24/whisper-go/whisper"
+)
+
+func TestConvertValue(t *testing.T) {
+	tests := []struct {
+		fieldType string
+		value     float64
+		want      interface{}
+		err       bool
+	}{
+		{"", 1.5, 1.5, false},
+		{"float", -2.25, -2.25, false},
+		{"integer", 42, int64(42), false},
+		{"integer", -7, int64(-7), false},
+		{"integer", 1.5, nil, true},
+		{"integer", math.Inf(1), nil, true},
+		{"integer", math.Pow(2, 63), nil, true},
+		{"integer", -math.Pow(2, 63), int64(math.MinInt64), false},
+		{"unsigned", 42, uint64(42), false},
+		{"unsigned", -1, nil, true},
+		{"unsigned", 1.5, nil, true},
+		{"unsigned", math.Pow(2, 63), uint64(1 << 63), false},
+		{"unsigned", math.Pow(2, 64), nil, true},
+		{"boolean", 0, false, false},
+		{"boolean", 1, true, false},
+		{"boolean", 2, nil, true},
+		{"boolean", 0.5, nil, true},
+	}
+	for _, test := range tests {
+		got, err := ConvertValue(test.fieldType, test.value)
+		if (err != nil) != test.err {
+			t.Errorf("ConvertValue(%q, %v) err %v", test.fieldType, test.value, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ConvertValue(%q, %v) = %#v, want %#v", test.fieldType, test.value,
+				got, test.want)
+		}
+	}
+}
+
+func TestIsValidFieldType(t *testing.T) {
+	for fieldType, want := range map[string]bool{"": true, "float": true,
+		"integer": true, "boolean": true, "unsigned": true, "string": false,
+		"int": false} {
+		if got := IsValidFieldType(fieldType); got != want {
+			t.Errorf("IsValidFieldType(%q) = %v, want %v", fieldType, got, want)
+		}
+	}
+}
+
+func TestInfluxDBValue(t *testing.T) {
+	if got, err := InfluxDBValue("unsigned", 42); err != nil || got != int64(42) {
+		t.Errorf("InfluxDBValue(unsigned, 42) = %#v, %v", got, err)
+	}
+	if _, err := InfluxDBValue("unsigned", math.Pow(2, 63)); err == nil {
+		t.Errorf("InfluxDBValue(unsigned, 2^63) did not overflow")
+	}
+	if got, err := InfluxDBValue("boolean", 1); err != nil || got != true {
+		t.Errorf("InfluxDBValue(boolean, 1) = %#v, %v", got, err)
+	}
+}
+
+func TestRejectLossyPoints(t *testing.T) {
+	migrationData := &MigrationData{}
+	mtf := &MTF{Measurement: "cpu", Field: "count", Type: "integer"}
+	points := migrationData.RejectLossyPoints("cpu.wsp", mtf, []whisper.Point{
+		{Timestamp: 60, Value: 1}, {Timestamp: 120, Value: 1.5},
+		{Timestamp: 180, Value: 3}})
+	if len(points) != 2 || points[1].Timestamp != 180 {
+		t.Errorf("points %v", points)
+	}
+	if migrationData.rejectedPoints != 1 {
+		t.Errorf("rejectedPoints %d", migrationData.rejectedPoints)
+	}
+}
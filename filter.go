@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/go-kit/log/level"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Repeatable string flag, e.g. -include=a.* -include=b.*
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+// Restricts which whisper files FindWhisperFiles picks up
+type FileFilter struct {
+	include        []*regexp.Regexp
+	exclude        []*regexp.Regexp
+	filesFrom      string
+	followSymlinks bool
+	minMtime       time.Time
+	maxMtime       time.Time
+	ctx            context.Context //stops the walk when canceled
+}
+
+// Compile -include and -exclude patterns. A pattern prefixed with re: is a
+// regular expression matched anywhere in the metric name, anything else is a
+// Graphite glob matched against the whole metric name
+func (filter *FileFilter) AddPatterns(includes []string, excludes []string) error {
+	for _, pattern := range includes {
+		re, err := CompileMetricPattern(pattern)
+		if err != nil {
+			return err
+		}
+		filter.include = append(filter.include, re)
+	}
+	for _, pattern := range excludes {
+		re, err := CompileMetricPattern(pattern)
+		if err != nil {
+			return err
+		}
+		filter.exclude = append(filter.exclude, re)
+	}
+	return nil
+}
+
+func CompileMetricPattern(pattern string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(pattern, "re:") {
+		return regexp.Compile(strings.TrimPrefix(pattern, "re:"))
+	}
+	return regexp.Compile(GlobToRegexp(pattern))
+}
+
+// Convert a Graphite glob to an anchored regular expression. Stars and
+// question marks never match across a path segment, [a-z] is a character
+// class and {a,b} are alternatives
+func GlobToRegexp(glob string) string {
+	var re strings.Builder
+	re.WriteString("^")
+	inBraces := false
+	for _, c := range glob {
+		switch {
+		case c == '*':
+			re.WriteString(`[^.]*`)
+		case c == '?':
+			re.WriteString(`[^.]`)
+		case c == '[' || c == ']' || c == '-':
+			re.WriteRune(c)
+		case c == '{':
+			inBraces = true
+			re.WriteString("(?:")
+		case c == '}' && inBraces:
+			inBraces = false
+			re.WriteString(")")
+		case c == ',' && inBraces:
+			re.WriteString("|")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	return re.String()
+}
+
+// Graphite metric name of a whisper file relative to the search dir, e.g.
+// whisper/carbon/agents/host1/cpu.wsp becomes carbon.agents.host1.cpu. A
+// -files-from list may give absolute paths for a relative search dir, or the
+// other way round
+func MetricName(searchDir string, wspFile string) string {
+	name := wspFile
+	rel, err := filepath.Rel(searchDir, wspFile)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		absDir, dirErr := filepath.Abs(searchDir)
+		absFile, fileErr := filepath.Abs(wspFile)
+		if dirErr == nil && fileErr == nil {
+			rel, err = filepath.Rel(absDir, absFile)
+		}
+	}
+	if err == nil && !strings.HasPrefix(rel, "..") {
+		name = rel
+	}
+	name = strings.TrimSuffix(name, ".wsp")
+	return strings.Replace(filepath.ToSlash(name), "/", ".", -1)
+}
+
+func (filter *FileFilter) MatchName(metric string) bool {
+	if len(filter.include) > 0 {
+		included := false
+		for _, re := range filter.include {
+			if re.MatchString(metric) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for _, re := range filter.exclude {
+		if re.MatchString(metric) {
+			return false
+		}
+	}
+	return true
+}
+
+// Include and exclude patterns match the metric name relative to wspPath, the
+// paths of a -files-from list have no metric name without it
+func (filter *FileFilter) NeedsSearchDir() bool {
+	return filter.filesFrom != "NULL" && filter.filesFrom != "" &&
+		(len(filter.include) > 0 || len(filter.exclude) > 0)
+}
+
+// Parse -min-mtime and -max-mtime, dates in UTC or NULL. Both days are
+// included, a file modified during the max day passes
+func (filter *FileFilter) SetMtimeRange(minMtime string, maxMtime string) error {
+	if minMtime != "NULL" {
+		t, err := time.Parse("2006-01-02", minMtime)
+		if err != nil {
+			return fmt.Errorf("invalid min-mtime %q", minMtime)
+		}
+		filter.minMtime = t
+	}
+	if maxMtime != "NULL" {
+		t, err := time.Parse("2006-01-02", maxMtime)
+		if err != nil {
+			return fmt.Errorf("invalid max-mtime %q", maxMtime)
+		}
+		filter.maxMtime = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return nil
+}
+
+func (filter *FileFilter) MatchMtime(mtime time.Time) bool {
+	if !filter.minMtime.IsZero() && mtime.Before(filter.minMtime) {
+		return false
+	}
+	if !filter.maxMtime.IsZero() && mtime.After(filter.maxMtime) {
+		return false
+	}
+	return true
+}
+
+// Hidden entries and leftovers of carbon or whisper-resize, never walked into
+func IsHiddenOrTemp(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") ||
+		strings.HasSuffix(name, ".tmp") || name == "lost+found"
+}
+
+// Walk dir and call found for every file whose name is wanted. Symlinked
+// directories are followed only with followSymlinks, visited holds the
+// resolved path of every directory walked so far so that symlink loops are
+// entered only once
+func (filter *FileFilter) walk(dir string, visited map[string]bool,
+	wanted func(name string) bool, found func(path string, f os.FileInfo)) error {
+
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if visited[realDir] {
+		return nil
+	}
+	visited[realDir] = true
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if filter.ctx != nil && filter.ctx.Err() != nil {
+			return filter.ctx.Err()
+		}
+		path := filepath.Join(dir, entry.Name())
+		f, err := os.Stat(path)
+		if err != nil { //dangling symlink or removed meanwhile
+			continue
+		}
+		if !f.IsDir() {
+			if wanted(entry.Name()) {
+				found(path, f)
+			}
+			continue
+		}
+		if IsHiddenOrTemp(entry.Name()) {
+			continue
+		}
+		if entry.Type()&os.ModeSymlink != 0 && !filter.followSymlinks {
+			continue
+		}
+		if err := filter.walk(path, visited, wanted, found); err != nil {
+			if filter.ctx != nil && filter.ctx.Err() != nil {
+				return err
+			}
+			level.Warn(logger).Log("msg", "Could not read directory", "phase", "scan",
+				"file", path, "err", err)
+		}
+	}
+	return nil
+}
+
+func IsWhisperFileName(name string) bool {
+	return strings.HasSuffix(name, ".wsp") && !IsHiddenOrTemp(name)
+}
+
+// Read the whisper file paths listed in filename, one per line. Empty lines
+// and lines starting with # are ignored, - reads the list from stdin
+func ReadFileList(filename string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if filename != "-" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var paths []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, line)
+	}
+	return paths, scanner.Err()
+}
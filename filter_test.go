@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob    string
+		want    string
+		match   []string
+		noMatch []string
+	}{
+		{"carbon.agents.*.cpu", `^carbon\.agents\.[^.]*\.cpu$`,
+			[]string{"carbon.agents.host1.cpu", "carbon.agents..cpu"},
+			[]string{"carbon.agents.a.b.cpu", "carbon.agents.host1.cpu2"}},
+		{"host?.load", `^host[^.]\.load$`,
+			[]string{"host1.load"},
+			[]string{"host.load", "host12.load", "host..load"}},
+		{"web[0-9].cpu", `^web[0-9]\.cpu$`,
+			[]string{"web3.cpu"},
+			[]string{"weba.cpu", "web10.cpu"}},
+		{"servers.{web,db}*.mem", `^servers\.(?:web|db)[^.]*\.mem$`,
+			[]string{"servers.web1.mem", "servers.db.mem"},
+			[]string{"servers.cache1.mem", "servers.web1.x.mem"}},
+		{"a,b", `^a,b$`, []string{"a,b"}, []string{"a"}},
+		{"stats_counts.+x", `^stats_counts\.\+x$`, []string{"stats_counts.+x"},
+			[]string{"stats_counts.x"}},
+	}
+	for _, test := range tests {
+		got := GlobToRegexp(test.glob)
+		if got != test.want {
+			t.Errorf("GlobToRegexp(%q) = %s, want %s", test.glob, got, test.want)
+			continue
+		}
+		re := regexp.MustCompile(got)
+		for _, metric := range test.match {
+			if !re.MatchString(metric) {
+				t.Errorf("%q does not match %q", test.glob, metric)
+			}
+		}
+		for _, metric := range test.noMatch {
+			if re.MatchString(metric) {
+				t.Errorf("%q matches %q", test.glob, metric)
+			}
+		}
+	}
+}
+
+func TestFileFilterMatchName(t *testing.T) {
+	filter := &FileFilter{}
+	if err := filter.AddPatterns([]string{"carbon.*.*", "re:^stats\\."},
+		[]string{"carbon.agents.*"}); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]bool{
+		"carbon.relays.a":    true,
+		"carbon.agents.a":    false,
+		"stats.timers.x":     true,
+		"carbon.relays.a.b":  false,
+		"collectd.host1.cpu": false,
+	}
+	for metric, want := range tests {
+		if got := filter.MatchName(metric); got != want {
+			t.Errorf("MatchName(%q) = %v, want %v", metric, got, want)
+		}
+	}
+}
+
+func TestMetricName(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		searchDir string
+		wspFile   string
+		want      string
+	}{
+		{"whisper", "whisper/carbon/agents/host1/cpu.wsp", "carbon.agents.host1.cpu"},
+		{"whisper/", "whisper/cpu.wsp", "cpu"},
+		{"other", "whisper/cpu.wsp", "whisper.cpu"},
+		//absolute paths of a -files-from list
+		{"whisper", filepath.Join(wd, "whisper/carbon/cpu.wsp"), "carbon.cpu"},
+		{filepath.Join(wd, "whisper"), "whisper/carbon/cpu.wsp", "carbon.cpu"},
+	}
+	for _, test := range tests {
+		if got := MetricName(test.searchDir, test.wspFile); got != test.want {
+			t.Errorf("MetricName(%q, %q) = %q, want %q", test.searchDir, test.wspFile,
+				got, test.want)
+		}
+	}
+}
+
+func TestNeedsSearchDir(t *testing.T) {
+	filter := &FileFilter{filesFrom: "files.txt"}
+	if filter.NeedsSearchDir() {
+		t.Errorf("files-from without patterns needs a search dir")
+	}
+	if err := filter.AddPatterns([]string{"carbon.*"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !filter.NeedsSearchDir() {
+		t.Errorf("files-from with -include does not need a search dir")
+	}
+	filter.filesFrom = "NULL"
+	if filter.NeedsSearchDir() {
+		t.Errorf("walking wspPath needs a search dir")
+	}
+}
+
+func TestSetMtimeRange(t *testing.T) {
+	filter := &FileFilter{}
+	if err := filter.SetMtimeRange("2015-11-01", "2015-12-30"); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[time.Time]bool{
+		time.Date(2015, 10, 31, 23, 59, 59, 0, time.UTC): false,
+		time.Date(2015, 11, 1, 0, 0, 0, 0, time.UTC):     true,
+		//the whole max day is included
+		time.Date(2015, 12, 30, 18, 0, 0, 0, time.UTC): true,
+		time.Date(2015, 12, 31, 0, 0, 0, 0, time.UTC):  false,
+	}
+	for mtime, want := range tests {
+		if got := filter.MatchMtime(mtime); got != want {
+			t.Errorf("MatchMtime(%s) = %v, want %v", mtime, got, want)
+		}
+	}
+	if err := filter.SetMtimeRange("NULL", "30/12/2015"); err == nil {
+		t.Errorf("invalid max-mtime parsed")
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Time a batch may take to be accepted by the carbon listener before the
+// connection is given up and the batch sent again on a new one
+const graphiteWriteTimeout = time.Minute
+
+// Replays the migrated points with the Graphite plaintext protocol to a
+// carbon-cache or relay. The metric is <measurement>.<field> followed by the
+// tags in the Graphite tag format, e.g. cpu.value;host=a
+type GraphiteSink struct {
+	ctx        context.Context
+	addr       string
+	batchSize  int
+	maxRetries int
+	limiter    *RateLimiter
+	conn       net.Conn
+	buf        bytes.Buffer
+	lines      int
+}
+
+func NewGraphiteSink(ctx context.Context, addr string, batchSize int, maxRetries int,
+	limiter *RateLimiter) *GraphiteSink {
+	return &GraphiteSink{ctx: ctx, addr: addr, batchSize: batchSize,
+		maxRetries: maxRetries, limiter: limiter}
+}
+
+// Graphite separates the fields of a line with spaces and tags with ; and =,
+// those and the characters carbon refuses in tags are replaced with _
+func SanitizeGraphiteName(name string) string {
+	return strings.Map(func(c rune) rune {
+		if c <= ' ' || strings.ContainsRune(";=!^~", c) {
+			return '_'
+		}
+		return c
+	}, name)
+}
+
+func GraphiteMetric(mtf *MTF) string {
+	metric := SanitizeGraphiteName(mtf.Measurement + "." + mtf.Field)
+	for _, tag := range mtf.Tags {
+		if tag.Tagvalue != "" {
+			metric = metric + ";" + SanitizeGraphiteName(tag.Tagkey) + "=" +
+				SanitizeGraphiteName(tag.Tagvalue)
+		}
+	}
+	return metric
+}
+
+func (sink *GraphiteSink) WriteSeries(mtf *MTF, wspPoints []whisper.Point) error {
+	metric := GraphiteMetric(mtf)
+	for _, wspPoint := range wspPoints {
+		if math.IsNaN(wspPoint.Value) || math.IsInf(wspPoint.Value, 0) {
+			continue
+		}
+		fmt.Fprintf(&sink.buf, "%s %s %d\n", metric,
+			strconv.FormatFloat(wspPoint.Value, 'f', -1, 64), wspPoint.Timestamp)
+		sink.lines++
+		if sink.lines >= sink.batchSize {
+			if err := sink.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Write the buffered lines. The write blocks while carbon does not read, which
+// slows down the migration instead of buffering without limit. A failed batch
+// is sent again in full on a new connection, carbon keeps the last value
+// written for a timestamp so lines received twice do no harm
+func (sink *GraphiteSink) flush() error {
+	if sink.lines == 0 {
+		return nil
+	}
+	sink.limiter.Wait(sink.lines)
+	err := DoWithRetry(sink.ctx, sink.maxRetries, func() (bool, error) {
+		if sink.conn == nil {
+			conn, err := net.DialTimeout("tcp", sink.addr, graphiteWriteTimeout)
+			if err != nil {
+				return true, err
+			}
+			sink.conn = conn
+		}
+		sink.conn.SetWriteDeadline(time.Now().Add(graphiteWriteTimeout))
+		if _, err := sink.conn.Write(sink.buf.Bytes()); err != nil {
+			sink.conn.Close()
+			sink.conn = nil
+			return true, err
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("Error in writing to carbon %s : %s", sink.addr, err)
+	}
+	sink.buf.Reset()
+	sink.lines = 0
+	return nil
+}
+
+func (sink *GraphiteSink) Close() error {
+	err := sink.flush()
+	if sink.conn != nil {
+		if closeErr := sink.conn.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
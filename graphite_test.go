@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/uttamgandhi24/whisper-go/whisper"
+)
+
+func TestGraphiteSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	lines := make(chan []string)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(lines)
+			return
+		}
+		defer conn.Close()
+		var received []string
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			received = append(received, scanner.Text())
+		}
+		lines <- received
+	}()
+
+	sink := NewGraphiteSink(context.Background(), listener.Addr().String(), 2, 0, nil)
+	mtf := &MTF{Measurement: "cpu", Field: "value",
+		Tags: []TagKeyValue{{Tagkey: "host", Tagvalue: "a;b"}}}
+	points := []whisper.Point{{Timestamp: 60, Value: 1.5}, {Timestamp: 120, Value: 2},
+		{Timestamp: 180, Value: 3}}
+	if err := sink.WriteSeries(mtf, points); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"cpu.value;host=a_b 1.5 60", "cpu.value;host=a_b 2 120",
+		"cpu.value;host=a_b 3 180"}
+	if got := <-lines; strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("lines %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/go-kit/log/level"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const influxDB2BatchSize = 5000
+
+// Writes to InfluxDB 2.x through /api/v2/write with token authentication
+type InfluxDB2Sink struct {
+	addr   string
+	org    string
+	bucket string
+	token  string
+	client *http.Client
+	lines  bytes.Buffer
+	count  int
+}
+
+func NewInfluxDB2Sink(addr string, org string, bucket string,
+	token string) *InfluxDB2Sink {
+	return &InfluxDB2Sink{
+		addr:   addr,
+		org:    org,
+		bucket: bucket,
+		token:  token,
+		client: &http.Client{Timeout: time.Minute},
+	}
+}
+
+// A response of InfluxDB other than 2xx
+type influxDB2Error struct {
+	statusCode int
+	msg        string
+}
+
+func (err *influxDB2Error) Error() string {
+	return err.msg
+}
+
+func (sink *InfluxDB2Sink) do(method string, path string, query url.Values,
+	body io.Reader, contentType string) ([]byte, error) {
+
+	if len(query) > 0 {
+		path = path + "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, sink.addr+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Token "+sink.token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := sink.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, &influxDB2Error{statusCode: resp.StatusCode,
+			msg: fmt.Sprintf("%s %s: %s %s", method, path, resp.Status,
+				bytes.TrimSpace(respBody))}
+	}
+	return respBody, nil
+}
+
+// Create the bucket with the given retention, 0 keeping data forever, unless
+// it exists already
+func (sink *InfluxDB2Sink) EnsureBucket(retention time.Duration) error {
+	//InfluxDB answers the lookup of a missing bucket with 404, older
+	//versions with an empty list
+	body, err := sink.do("GET", "/api/v2/buckets",
+		url.Values{"org": {sink.org}, "name": {sink.bucket}}, nil, "")
+	if statusErr, ok := err.(*influxDB2Error); ok &&
+		statusErr.statusCode == http.StatusNotFound {
+		body, err = []byte(`{"buckets": []}`), nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error in looking up bucket : %s", err)
+	}
+	var buckets struct {
+		Buckets []struct {
+			Name string `json:"name"`
+		} `json:"buckets"`
+	}
+	if err := json.Unmarshal(body, &buckets); err != nil {
+		return err
+	}
+	for _, bucket := range buckets.Buckets {
+		if bucket.Name == sink.bucket {
+			return nil
+		}
+	}
+
+	body, err = sink.do("GET", "/api/v2/orgs", url.Values{"org": {sink.org}}, nil, "")
+	if err != nil {
+		return fmt.Errorf("Error in looking up org : %s", err)
+	}
+	var orgs struct {
+		Orgs []struct {
+			ID string `json:"id"`
+		} `json:"orgs"`
+	}
+	if err := json.Unmarshal(body, &orgs); err != nil {
+		return err
+	}
+	if len(orgs.Orgs) == 0 {
+		return fmt.Errorf("Org %s not found", sink.org)
+	}
+
+	type retentionRule struct {
+		Type         string `json:"type"`
+		EverySeconds int64  `json:"everySeconds"`
+	}
+	bucket := struct {
+		OrgID          string          `json:"orgID"`
+		Name           string          `json:"name"`
+		RetentionRules []retentionRule `json:"retentionRules"`
+	}{OrgID: orgs.Orgs[0].ID, Name: sink.bucket, RetentionRules: []retentionRule{}}
+	if retention > 0 {
+		bucket.RetentionRules = append(bucket.RetentionRules,
+			retentionRule{Type: "expire", EverySeconds: int64(retention / time.Second)})
+	}
+	raw, err := json.Marshal(bucket)
+	if err != nil {
+		return err
+	}
+	if _, err := sink.do("POST", "/api/v2/buckets", nil,
+		bytes.NewReader(raw), "application/json"); err != nil {
+		return fmt.Errorf("Error in creating bucket : %s", err)
+	}
+	level.Info(logger).Log("msg", "Created bucket", "bucket", sink.bucket,
+		"retention", retention)
+	return nil
+}
+
+func (sink *InfluxDB2Sink) WriteSeries(mtf *MTF, wspPoints []whisper.Point) error {
+	pts, err := NewSeriesPoints(mtf, wspPoints)
+	if err != nil {
+		return err
+	}
+	for _, pt := range pts {
+		sink.lines.WriteString(pt.PrecisionString("s"))
+		sink.lines.WriteByte('\n')
+		sink.count = sink.count + 1
+		if sink.count >= influxDB2BatchSize {
+			if err := sink.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (sink *InfluxDB2Sink) flush() error {
+	if sink.count == 0 {
+		return nil
+	}
+	query := url.Values{"org": {sink.org}, "bucket": {sink.bucket}, "precision": {"s"}}
+	if _, err := sink.do("POST", "/api/v2/write", query, &sink.lines,
+		"text/plain; charset=utf-8"); err != nil {
+		return fmt.Errorf("Error in writing points : %s", err)
+	}
+	sink.lines.Reset()
+	sink.count = 0
+	return nil
+}
+
+func (sink *InfluxDB2Sink) Close() error {
+	return sink.flush()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uttamgandhi24/whisper-go/whisper"
+)
+
+func TestInfluxDB2SinkCreatesMissingBucket(t *testing.T) {
+	var requests []string
+	var created map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if got := r.Header.Get("Authorization"); got != "Token secret" {
+			t.Errorf("Authorization = %q", got)
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api/v2/buckets":
+			if r.URL.Query().Get("org") != "acme" || r.URL.Query().Get("name") != "graphite" {
+				t.Errorf("bucket lookup query %s", r.URL.RawQuery)
+			}
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":"not found","message":"bucket \"graphite\" not found"}`))
+		case "GET /api/v2/orgs":
+			w.Write([]byte(`{"orgs":[{"id":"org1"}]}`))
+		case "POST /api/v2/buckets":
+			body, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(body, &created)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	sink := NewInfluxDB2Sink(server.URL, "acme", "graphite", "secret")
+	if err := sink.EnsureBucket(24 * time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"GET /api/v2/buckets", "GET /api/v2/orgs", "POST /api/v2/buckets"}
+	if strings.Join(requests, ",") != strings.Join(want, ",") {
+		t.Fatalf("requests %v, want %v", requests, want)
+	}
+	if created["orgID"] != "org1" || created["name"] != "graphite" {
+		t.Fatalf("created bucket %v", created)
+	}
+	rules := created["retentionRules"].([]interface{})
+	if len(rules) != 1 || rules[0].(map[string]interface{})["everySeconds"].(float64) != 86400 {
+		t.Fatalf("retention rules %v", rules)
+	}
+}
+
+func TestInfluxDB2SinkKeepsExistingBucket(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/api/v2/buckets" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		w.Write([]byte(`{"buckets":[{"name":"graphite"}]}`))
+	}))
+	defer server.Close()
+
+	if err := NewInfluxDB2Sink(server.URL, "acme", "graphite", "secret").EnsureBucket(0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInfluxDB2SinkWrite(t *testing.T) {
+	var query, auth, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/v2/write" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		query = r.URL.RawQuery
+		auth = r.Header.Get("Authorization")
+		raw, _ := ioutil.ReadAll(r.Body)
+		body = string(raw)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := NewInfluxDB2Sink(server.URL, "acme", "graphite", "secret")
+	mtf := &MTF{Measurement: "cpu", Field: "value",
+		Tags: []TagKeyValue{{Tagkey: "host", Tagvalue: "web1"}}}
+	points := []whisper.Point{{Timestamp: 60, Value: 1.5}, {Timestamp: 120, Value: 2}}
+	if err := sink.WriteSeries(mtf, points); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if query != "bucket=graphite&org=acme&precision=s" {
+		t.Errorf("query %q", query)
+	}
+	if auth != "Token secret" {
+		t.Errorf("Authorization %q", auth)
+	}
+	if body != "cpu,host=web1 value=1.5 60\ncpu,host=web1 value=2 120\n" {
+		t.Errorf("body %q", body)
+	}
+}
+
+func TestInfluxDB2SinkWriteError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message":"unauthorized access"}`))
+	}))
+	defer server.Close()
+
+	sink := NewInfluxDB2Sink(server.URL, "acme", "graphite", "wrong")
+	sink.WriteSeries(&MTF{Measurement: "cpu", Field: "value"},
+		[]whisper.Point{{Timestamp: 60, Value: 1}})
+	if err := sink.Close(); err == nil || !strings.Contains(err.Error(), "unauthorized") {
+		t.Fatalf("err %v", err)
+	}
+}
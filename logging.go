@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"os"
+)
+
+// The log of the migration on stderr, logfmt at info level until SetupLogger
+// applies -log-format and -log-level. Messages carry the fields file, series,
+// shard and phase where they apply. The preview, the prompts and the summary
+// are not log messages and stay on stdout
+var logger = NewLogger(os.Stderr, "logfmt", level.InfoValue())
+
+func NewLogger(w *os.File, format string, lvl level.Value) kitlog.Logger {
+	var l kitlog.Logger
+	if format == "json" {
+		l = kitlog.NewJSONLogger(kitlog.NewSyncWriter(w))
+	} else {
+		l = kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(w))
+	}
+	l = kitlog.With(l, "ts", kitlog.DefaultTimestampUTC)
+	return level.NewFilter(l, level.Allow(lvl))
+}
+
+// Log with the level and format given, format is logfmt or json and the
+// level debug, info, warn or error
+func SetupLogger(format string, lvl string) error {
+	if format != "logfmt" && format != "json" {
+		return fmt.Errorf("unknown log format %s", format)
+	}
+	value, err := level.Parse(lvl)
+	if err != nil {
+		return err
+	}
+	logger = NewLogger(os.Stderr, format, value)
+	return nil
+}
+
+// Log the error the migration stopped on and exit with status 1
+func ExitWithError(msg string, err error) {
+	level.Error(logger).Log("msg", msg, "err", err)
+	os.Exit(1)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"github.com/go-kit/log/level"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Writes the migrated points as line protocol files which can be loaded with
+// influx -import -path=<file> [-compressed]. Files are split when they grow
+// past splitSize bytes and, with splitDuration, per time range so that every
+// file only holds points of one shard group
+type LPSink struct {
+	dir             string
+	dbName          string
+	retentionPolicy string
+	gzip            bool
+	splitSize       int64
+	splitDuration   time.Duration
+	files           map[int64]*lpFile
+	sequence        int
+	writes          int64
+}
+
+// Files open at once with -lp-split-duration. The whisper files are read one
+// after the other, each spanning many time ranges, so the ranges are not done
+// in order and the file of the range written to least recently is closed
+const lpMaxOpenFiles = 64
+
+type lpFile struct {
+	name     string
+	f        *os.File
+	gz       *gzip.Writer
+	w        *bufio.Writer
+	size     int64
+	lastUsed int64
+}
+
+// Counts the bytes written to the file, after compression
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (cw countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	*cw.n = *cw.n + int64(n)
+	return n, err
+}
+
+func NewLPSink(dir string, dbName string, retentionPolicy string, gzip bool,
+	splitSize int64, splitDuration time.Duration) (*LPSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &LPSink{
+		dir:             dir,
+		dbName:          dbName,
+		retentionPolicy: retentionPolicy,
+		gzip:            gzip,
+		splitSize:       splitSize,
+		splitDuration:   splitDuration,
+		files:           make(map[int64]*lpFile),
+	}, nil
+}
+
+// Open the next file for the time range starting at rangeStart and write the
+// DDL/DML header influx -import expects
+func (sink *LPSink) open(rangeStart int64) (*lpFile, error) {
+	sink.sequence = sink.sequence + 1
+	name := sink.dbName
+	if sink.splitDuration > 0 {
+		name = name + "-" + time.Unix(rangeStart, 0).UTC().Format("20060102")
+	}
+	name = fmt.Sprintf("%s-%04d.lp", name, sink.sequence)
+	if sink.gzip {
+		name = name + ".gz"
+	}
+	name = filepath.Join(sink.dir, name)
+
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	file := &lpFile{name: name, f: f}
+	var w io.Writer = countingWriter{w: f, n: &file.size}
+	if sink.gzip {
+		file.gz = gzip.NewWriter(w)
+		w = file.gz
+	}
+	file.w = bufio.NewWriter(w)
+	header := fmt.Sprintf("# DDL\nCREATE DATABASE %s\n\n# DML\n"+
+		"# CONTEXT-DATABASE: %s\n# CONTEXT-RETENTION-POLICY: %s\n\n",
+		sink.dbName, sink.dbName, sink.retentionPolicy)
+	if _, err := file.w.WriteString(header); err != nil {
+		file.close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func (file *lpFile) close() error {
+	err := file.w.Flush()
+	if file.gz != nil {
+		if gzErr := file.gz.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	if closeErr := file.f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		if fileinfo, statErr := os.Stat(file.name); statErr == nil {
+			level.Info(logger).Log("msg", "Line protocol file written", "phase", "write",
+				"file", file.name, "size", fileinfo.Size())
+		}
+	}
+	return err
+}
+
+func (sink *LPSink) WriteSeries(mtf *MTF, wspPoints []whisper.Point) error {
+	pts, err := NewSeriesPoints(mtf, wspPoints)
+	if err != nil {
+		return err
+	}
+	for i, pt := range pts {
+		var rangeStart int64
+		if sink.splitDuration > 0 {
+			timestamp := int64(wspPoints[i].Timestamp)
+			rangeStart = timestamp - timestamp%int64(sink.splitDuration/time.Second)
+		}
+		file := sink.files[rangeStart]
+		if file == nil {
+			if len(sink.files) >= lpMaxOpenFiles {
+				if err := sink.closeLeastRecentlyUsed(); err != nil {
+					return err
+				}
+			}
+			if file, err = sink.open(rangeStart); err != nil {
+				return err
+			}
+			sink.files[rangeStart] = file
+		}
+		sink.writes = sink.writes + 1
+		file.lastUsed = sink.writes
+		if _, err := file.w.WriteString(pt.String() + "\n"); err != nil {
+			return err
+		}
+		//the size lags behind by what bufio and gzip still buffer
+		if sink.splitSize > 0 && file.size >= sink.splitSize {
+			delete(sink.files, rangeStart)
+			if err := file.close(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (sink *LPSink) closeLeastRecentlyUsed() error {
+	var oldest int64
+	var oldestFile *lpFile
+	for rangeStart, file := range sink.files {
+		if oldestFile == nil || file.lastUsed < oldestFile.lastUsed {
+			oldest, oldestFile = rangeStart, file
+		}
+	}
+	delete(sink.files, oldest)
+	return oldestFile.close()
+}
+
+func (sink *LPSink) Close() error {
+	var err error
+	for rangeStart, file := range sink.files {
+		if closeErr := file.close(); err == nil {
+			err = closeErr
+		}
+		delete(sink.files, rangeStart)
+	}
+	return err
+}
+
+// Parse a size such as 512MB or 2GB, a bare number is in bytes
+func ParseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1073741824}, {"MB", 1048576}, {"KB", 1024}, {"B", 1}}
+	s = strings.ToUpper(strings.TrimSpace(s))
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			n, err := strconv.ParseFloat(strings.TrimSuffix(s, unit.suffix), 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid size %s", s)
+			}
+			return int64(n * float64(unit.size)), nil
+		}
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
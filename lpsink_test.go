@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uttamgandhi24/whisper-go/whisper"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int64
+		err  bool
+	}{
+		{"512MB", 512 * 1048576, false},
+		{"2gb", 2 * 1073741824, false},
+		{"1.5KB", 1536, false},
+		{"100B", 100, false},
+		{"4096", 4096, false},
+		{"xMB", 0, true},
+	}
+	for _, test := range tests {
+		got, err := ParseSize(test.s)
+		if (err != nil) != test.err || got != test.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", test.s, got, err, test.want)
+		}
+	}
+}
+
+// Points spread over more time ranges than files may be open, every point
+// must end up in a file of its range
+func TestLPSinkSplitDuration(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewLPSink(dir, "db", "autogen", true, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtf := &MTF{Measurement: "cpu", Field: "value"}
+	ranges := lpMaxOpenFiles + 10
+	for pass := 0; pass < 2; pass++ {
+		var points []whisper.Point
+		for i := 0; i < ranges; i++ {
+			points = append(points, whisper.Point{Timestamp: uint32(i*3600 + pass), Value: 1})
+		}
+		if err := sink.WriteSeries(mtf, points); err != nil {
+			t.Fatal(err)
+		}
+		if len(sink.files) > lpMaxOpenFiles {
+			t.Fatalf("%d files open", len(sink.files))
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names, _ := filepath.Glob(filepath.Join(dir, "*.lp.gz"))
+	lines := 0
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner := bufio.NewScanner(gz)
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), "cpu ") {
+				lines++
+			}
+		}
+		f.Close()
+	}
+	if lines != 2*ranges {
+		t.Errorf("%d lines in %d files, want %d", lines, len(names), 2*ranges)
+	}
+}
+
+func TestLPSinkSplitSizeCountsCompressedBytes(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewLPSink(dir, "db", "autogen", true, 8192, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var points []whisper.Point
+	for i := 0; i < 100000; i++ {
+		points = append(points, whisper.Point{Timestamp: uint32(i * 60), Value: 1})
+	}
+	if err := sink.WriteSeries(&MTF{Measurement: "cpu", Field: "value"}, points); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	names, _ := filepath.Glob(filepath.Join(dir, "*.lp.gz"))
+	if len(names) < 2 {
+		t.Fatalf("%d files, want a split", len(names))
+	}
+	for _, name := range names[:len(names)-1] {
+		fileinfo, _ := os.Stat(name)
+		//gzip and bufio buffer up to a few deflate blocks past the split size
+		if fileinfo.Size() < 8192 || fileinfo.Size() > 8192+256*1024 {
+			t.Errorf("%s has %d bytes", name, fileinfo.Size())
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The metrics are always counted and only served with -metrics-addr
+var (
+	filesProcessed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "whisper_migrator_files_processed_total",
+		Help: "Whisper files read, once per pass",
+	})
+	pointsRead = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "whisper_migrator_points_read_total",
+		Help: "Points read from the whisper files",
+	})
+	pointsWritten = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "whisper_migrator_points_written_total",
+		Help: "Points written to the migration target",
+	})
+	pointsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "whisper_migrator_points_dropped_total",
+		Help: "Points left out, by reason",
+	}, []string{"reason"})
+	lastWrite = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "whisper_migrator_last_write_timestamp_seconds",
+		Help: "Time of the last points written, to alert on a stalled migration",
+	})
+	writeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "whisper_migrator_write_duration_seconds",
+		Help:    "Time taken by a write to the migration target",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"option"})
+	writeRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "whisper_migrator_write_retries_total",
+		Help: "Failed writes which were retried",
+	})
+	migrationErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "whisper_migrator_errors_total",
+		Help: "Errors by type: quarantine, read or write",
+	}, []string{"type"})
+	currentShard = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "whisper_migrator_current_shard",
+		Help: "Id of the shard written by the TSMW option",
+	})
+)
+
+// Serve the metrics in the Prometheus format on addr/metrics. The listener
+// is opened before returning, so that an address in use is reported
+func ServeMetrics(addr string) error {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(filesProcessed, pointsRead, pointsWritten,
+		pointsDropped, lastWrite, writeDuration, writeRetries,
+		migrationErrors, currentShard,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	go http.Serve(listener, mux)
+	return nil
+}
+
+// Count n points written by a write which started at start
+func ObserveWrite(option string, start time.Time, n int) {
+	writeDuration.WithLabelValues(option).Observe(time.Since(start).Seconds())
+	pointsWritten.Add(float64(n))
+	lastWrite.SetToCurrentTime()
+}
+
+// The TSMW option writes the shard with this id
+func SetCurrentShard(id string) {
+	if n, err := strconv.ParseFloat(id, 64); err == nil {
+		currentShard.Set(n)
+	}
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"github.com/go-kit/log/level"
 	"github.com/influxdata/influxdb/client/v2"
 	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
 	"github.com/uttamgandhi24/whisper-go/whisper"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
@@ -32,7 +35,86 @@ func usage() {
 		migration.go -option=ClientV2 -wspPath=whisper folder
 		-from=<2015-11-01> -until=<2015-12-30> -dbname=migrated -host=http://localhost
 		-port=8086, -retentionPolicy=default -tagconfig=config.json -username=<username>,
-		-password=<password>`)
+		-password=<password>
+
+		OR
+
+		migration.go -option=InfluxDB2 -wspPath=whisper folder
+		-from=<2015-11-01> -until=<2015-12-30> -host=http://localhost -port=8086
+		-org=<org> -bucket=<bucket> -token=<token> -tagconfig=config.json
+
+		OR
+
+		migration.go -option=LP -wspPath=whisper folder -lp-dir=output folder
+		-from=<2015-11-01> -until=<2015-12-30> -dbname=migrated
+		-retentionPolicy=default -tagconfig=config.json [-lp-gzip]
+		[-lp-split-size=<512MB>] [-lp-split-duration=<7d>]
+
+		OR
+
+		migration.go -option=<CSV|Parquet> -wspPath=whisper folder
+		-export-dir=output folder -from=<2015-11-01> -until=<2015-12-30>
+		-tagconfig=config.json
+
+		OR
+
+		migration.go -option=PromRemoteWrite -wspPath=whisper folder
+		-remote-write-url=http://localhost:9090/api/v1/write
+		-from=<2015-11-01> -until=<2015-12-30> -tagconfig=config.json
+		[-username=<username> -password=<password>] [-max-retries=5]
+
+		OR
+
+		migration.go -option=TSDB -wspPath=whisper folder -tsdb-dir=blocks folder
+		-from=<2015-11-01> -until=<2015-12-30> -tagconfig=config.json
+		[-tsdb-block-duration=2h]
+
+		OR
+
+		migration.go -option=OpenTSDB -wspPath=whisper folder
+		-opentsdb-url=http://localhost:4242
+		-from=<2015-11-01> -until=<2015-12-30> -tagconfig=config.json
+		[-username=<username> -password=<password>] [-batch-size=5000]
+		[-rate-limit=<points per second>] [-max-retries=5]
+
+		OR
+
+		migration.go -option=Graphite -wspPath=whisper folder
+		-graphite-addr=localhost:2003
+		-from=<2015-11-01> -until=<2015-12-30> -tagconfig=config.json
+		[-batch-size=5000] [-rate-limit=<points per second>] [-max-retries=5]
+
+		OR
+
+		migration.go -option=Whisper -wspPath=whisper folder
+		-whisper-dir=output folder -whisper-schema=10s:1d,1m:30d,1h:5y
+		-from=<2015-11-01> [-until=<2015-12-30>]
+		[-whisper-aggregation=average] [-whisper-xff=0.5]
+
+		-wspPath may also be a .tar, .tar.gz or .tar.zst archive of a whisper folder
+		-source=ceres reads a Ceres tree from wspPath instead of whisper files
+
+		Optional for all options
+		-quarantine=<file> records corrupt whisper files with the reason
+		-include=<glob|re:regex> -exclude=<glob|re:regex> (repeatable)
+		-files-from=<file> -follow-symlinks
+		-default-tags=<key=value,...> adds the tags to every series
+		-resample=<5m> [-resample-aggregate=mean] [-resample-xff=0]
+		-from and -until also take RFC3339 or relative times like -90d
+		-tz=<Europe/Amsterdam> -time-shift=<365d>
+		-min-mtime=<2015-11-01> -max-mtime=<2015-12-30>
+		-progress-interval=<10s> between progress lines, 0 for none
+		-metrics-addr=<:9100> serves Prometheus metrics on /metrics
+		-log-level=<debug|info|warn|error> -log-format=<logfmt|json>
+		-report=<file> writes a JSON report of the migration
+
+		Optional for the TSMW and ClientV2 options
+		-skip-stale=<365d> -stale-report=<file>
+		-dedup=<truncate|target|source> for data already in InfluxDB
+
+		Optional for the ClientV2, InfluxDB2, PromRemoteWrite, OpenTSDB and
+		Graphite options
+		-sync [-sync-interval=1m] [-sync-state=sync_state.json] [-sync-inotify]`)
 }
 
 type ShardInfo struct {
@@ -57,6 +139,50 @@ type MigrationData struct {
 	port            string
 	username        string
 	password        string
+	quarantineList  string
+	quarantined     []QuarantinedFile
+	quarantinedSet  map[string]bool
+	filter          *FileFilter
+	staleFiles      int
+	rejectedPoints  int
+	resolutions     map[string]uint32
+	defaultTags     []TagKeyValue
+	transformStates map[string][]transformState
+	resample        *Resample
+	timeShift       time.Duration
+	dedup           string
+	existing        map[string]*ExistingSeries
+	dedupedPoints   int
+	syncState       string
+	syncInterval    time.Duration
+	syncInotify     bool
+	unmatched       map[string]bool
+	wspArchive      string
+	wspFileSizes    map[string]int64
+	source          string
+	org             string
+	bucket          string
+	token           string
+	lpDir           string
+	lpGzip          bool
+	lpSplitSize     int64
+	lpSplitDuration time.Duration
+	exportDir       string
+	remoteWriteURL  string
+	maxRetries      int
+	tsdbDir         string
+	tsdbBlockRange  time.Duration
+	openTSDBURL     string
+	graphiteAddr    string
+	batchSize       int
+	rateLimit       float64
+	wspPath         string
+	whisperDir      string
+	whisperSchema   *WhisperHeader
+	progress        *Progress
+	report          *Report
+	ctx             context.Context
+	filesRead       int
 }
 
 type TsmPoint struct {
@@ -74,21 +200,34 @@ type TagConfig struct {
 	Measurement string        `json:"measurement"`
 	Tags        []TagKeyValue `json:"tags"`
 	Field       string        `json:"field"`
+	Type        string        `json:"type,omitempty"`
+	Transforms  []Transform   `json:"transforms,omitempty"`
+	Resample    *Resample     `json:"resample,omitempty"`
 }
 
 type MTF struct {
 	Measurement string
 	Tags        []TagKeyValue
 	Field       string
+	Type        string
+	Transforms  []Transform
+	Resample    *Resample
 }
 
 func main() {
 	var (
-		option          = flag.String("option", "NULL", "Use TSMWriter or ClientV2 for migration")
+		option          = flag.String("option", "NULL", "Use TSMWriter, ClientV2, InfluxDB2, LP, CSV, Parquet, PromRemoteWrite, TSDB, OpenTSDB or Graphite or Whisper for migration")
 		wspPath         = flag.String("wspPath", "NULL", "Whisper files folder path")
 		influxDataDir   = flag.String("influxDataDir", "NULL", "InfluxDB data directory")
-		from            = flag.String("from", "NULL", "from date in YYYY-MM-DD format")
-		until           = flag.String("until", "NULL", "until date in YYYY-MM-DD format")
+		from            = flag.String("from", "NULL", "from date in YYYY-MM-DD or RFC3339 format, or relative e.g. -90d")
+		until           = flag.String("until", "NULL", "until date in YYYY-MM-DD or RFC3339 format, or relative e.g. -1d (default: now)")
+		tz              = flag.String("tz", "UTC", "Time zone of -from and -until dates, e.g. Europe/Amsterdam")
+		syncMode        = flag.Bool("sync", false, "Keep writing new whisper points after the migration")
+		syncInterval    = flag.String("sync-interval", "1m", "Time between two sync passes")
+		syncState       = flag.String("sync-state", "sync_state.json", "File keeping the last point synced of every whisper file")
+		syncInotify     = flag.Bool("sync-inotify", false, "Sync the whisper files inotify reports as written instead of checking mtimes")
+		dedup           = flag.String("dedup", "source", "On data already in InfluxDB: truncate, target or source wins")
+		timeShift       = flag.String("time-shift", "NULL", "Move all points by this duration, e.g. 365d or -12h")
 		dbName          = flag.String("dbname", "migrated", "Database name (default: migrated")
 		tagConfigFile   = flag.String("tagconfig", "NULL", "Configuration file for measurement and tags")
 		retentionPolicy = flag.String("retentionPolicy", "default", "Retention Policy")
@@ -97,17 +236,82 @@ func main() {
 		username        = flag.String("username", "NULL", "Username for influxdb auth")
 		password        = flag.String("password", "NULL", "Password for influxdb auth")
 		wspinfo         = flag.Bool("wspinfo", false, "Whisper file information")
+		quarantineList  = flag.String("quarantine", "NULL", "File listing corrupt whisper files with the reason")
+		filesFrom       = flag.String("files-from", "NULL", "File listing whisper files to migrate, - for stdin")
+		followSymlinks  = flag.Bool("follow-symlinks", false, "Follow symlinked directories under wspPath")
+		minMtime        = flag.String("min-mtime", "NULL", "Skip whisper files modified before YYYY-MM-DD")
+		maxMtime        = flag.String("max-mtime", "NULL", "Skip whisper files modified after YYYY-MM-DD")
+		org             = flag.String("org", "NULL", "InfluxDB 2.x organization")
+		bucket          = flag.String("bucket", "NULL", "InfluxDB 2.x bucket (default: dbname)")
+		token           = flag.String("token", "NULL", "InfluxDB 2.x API token")
+		lpDir           = flag.String("lp-dir", ".", "Output folder for the LP option")
+		lpGzip          = flag.Bool("lp-gzip", false, "Gzip the line protocol files")
+		lpSplitSize     = flag.String("lp-split-size", "NULL", "Start a new line protocol file after this size, e.g. 512MB")
+		lpSplitDuration = flag.String("lp-split-duration", "NULL", "One line protocol file per time range, e.g. 7d for weekly shards")
+		exportDir       = flag.String("export-dir", ".", "Output folder for the CSV and Parquet options")
+		remoteWriteURL  = flag.String("remote-write-url", "NULL", "Prometheus remote write endpoint for the PromRemoteWrite option")
+		maxRetries      = flag.Int("max-retries", 5, "Retries of a failed write before the migration stops")
+		tsdbDir         = flag.String("tsdb-dir", "NULL", "Output folder for the TSDB option")
+		tsdbBlockRange  = flag.String("tsdb-block-duration", "2h", "Time range of every TSDB block")
+		openTSDBURL     = flag.String("opentsdb-url", "NULL", "OpenTSDB address for the OpenTSDB option, e.g. http://localhost:4242")
+		graphiteAddr    = flag.String("graphite-addr", "NULL", "Carbon plaintext listener for the Graphite option, e.g. localhost:2003")
+		batchSize       = flag.Int("batch-size", 5000, "Points sent at once by the OpenTSDB and Graphite options")
+		rateLimit       = flag.Float64("rate-limit", 0, "Points per second sent by the OpenTSDB and Graphite options, 0 for no limit")
+		resample        = flag.String("resample", "NULL", "Resample every series to this interval, e.g. 5m")
+		resampleAgg     = flag.String("resample-aggregate", "mean", "mean, sum, min, max, last or count of the points resampled")
+		resampleXFF     = flag.Float64("resample-xff", 0, "Known fraction of the points resampled needed to write a point")
+		defaultTags     = flag.String("default-tags", "NULL", "Tags added to every series, e.g. source=graphite,dc=ams1")
+		whisperDir      = flag.String("whisper-dir", "NULL", "Output folder for the Whisper option")
+		whisperSchema   = flag.String("whisper-schema", "NULL", "Archives of the new whisper files, e.g. 10s:1d,1m:30d,1h:5y")
+		whisperAgg      = flag.String("whisper-aggregation", "average", "Aggregation method of the new whisper files")
+		whisperXFF      = flag.Float64("whisper-xff", 0.5, "xFilesFactor of the new whisper files")
+		source          = flag.String("source", "whisper", "Format of the files under wspPath, whisper or ceres")
+		skipStale       = flag.String("skip-stale", "NULL", "Skip metrics not written for this long, e.g. 365d")
+		staleReport     = flag.String("stale-report", "stale_report.txt", "File listing the metrics skipped as stale")
+		reportFile      = flag.String("report", "NULL", "Write a JSON report of the migration to this file")
+		logLevel        = flag.String("log-level", "info", "Log debug, info, warn or error messages and above")
+		logFormat       = flag.String("log-format", "logfmt", "Log messages as logfmt or json")
+		metricsAddr     = flag.String("metrics-addr", "NULL", "Serve Prometheus metrics on this address, e.g. :9100")
+		progressEvery   = flag.String("progress-interval", "10s", "Time between two progress lines when not on a terminal, 0 for no progress")
+		includes        stringList
+		excludes        stringList
 	)
+	flag.Var(&includes, "include", "Only migrate metrics matching the glob or re:regex, repeatable")
+	flag.Var(&excludes, "exclude", "Skip metrics matching the glob or re:regex, repeatable")
 	flag.Parse()
 
+	if err := SetupLogger(*logFormat, *logLevel); err != nil {
+		log.Fatal("Error in parsing log-level or log-format ", err)
+	}
+	if *source != "whisper" && *source != "ceres" {
+		usage()
+	}
+	if *source == "ceres" && IsTarArchive(*wspPath) {
+		log.Fatal("Ceres trees can not be read from a tar archive")
+	}
+	ctx := CancelOnSignal()
+	filter := &FileFilter{filesFrom: *filesFrom, followSymlinks: *followSymlinks,
+		ctx: ctx}
+	if err := filter.AddPatterns(includes, excludes); err != nil {
+		log.Fatal("Error in parsing include/exclude pattern ", err)
+	}
+	if err := filter.SetMtimeRange(*minMtime, *maxMtime); err != nil {
+		log.Fatal("Error in parsing mtime range ", err)
+	}
+	if filter.NeedsSearchDir() && *wspPath == "NULL" {
+		log.Fatal("-include and -exclude with -files-from need -wspPath, the folder the metric names start at")
+	}
+
 	//Handle whisper information menu
 	if *wspinfo == true {
-		if *wspPath == "NULL" {
+		if *wspPath == "NULL" && *filesFrom == "NULL" {
 			usage()
 		}
-		migrationData := &MigrationData{}
+		migrationData := &MigrationData{quarantineList: *quarantineList,
+			filter: filter, source: *source, ctx: ctx}
 
 		migrationData.FindWhisperFiles(*wspPath)
+		migrationData.ValidateWhisperFiles()
 		if len(migrationData.wspFiles) == 0 {
 			fmt.Println("No Whisper files found")
 			return
@@ -119,8 +323,9 @@ func main() {
 	}
 
 	//Handle mandatory parameters
-	if *option == "NULL" || *wspPath == "NULL" || *from == "NULL" ||
-		*tagConfigFile == "NULL" {
+	if *option == "NULL" || (*wspPath == "NULL" && *filesFrom == "NULL") ||
+		*from == "NULL" ||
+		(*tagConfigFile == "NULL" && *option != "Whisper") {
 		usage()
 	}
 
@@ -129,6 +334,42 @@ func main() {
 		usage()
 	}
 
+	// Org and token are mandatory for InfluxDB2 option
+	if *option == "InfluxDB2" && (*org == "NULL" || *token == "NULL") {
+		usage()
+	}
+	if *option == "PromRemoteWrite" && *remoteWriteURL == "NULL" {
+		usage()
+	}
+	if *option == "TSDB" && *tsdbDir == "NULL" {
+		usage()
+	}
+	if *option == "OpenTSDB" && *openTSDBURL == "NULL" {
+		usage()
+	}
+	if *option == "Graphite" && *graphiteAddr == "NULL" {
+		usage()
+	}
+	if *batchSize < 1 {
+		usage()
+	}
+	if *option == "Whisper" && (*whisperDir == "NULL" || *whisperSchema == "NULL") {
+		usage()
+	}
+	if *syncMode && (!IsSyncOption(*option) || IsTarArchive(*wspPath) ||
+		(*syncInotify && *wspPath == "NULL")) {
+		log.Fatal("sync needs a whisper folder and the ClientV2, InfluxDB2, PromRemoteWrite, OpenTSDB or Graphite option")
+	}
+	switch {
+	case *dedup != "truncate" && *dedup != "target" && *dedup != "source":
+		usage()
+	case *dedup != "source" && *option != "TSMW" && *option != "ClientV2":
+		log.Fatal("dedup needs the TSMW or ClientV2 option")
+	}
+	if !IsValidOption(*option) {
+		usage()
+	}
+
 	migrationData := &MigrationData{
 		option:          *option,
 		dbName:          *dbName,
@@ -138,85 +379,316 @@ func main() {
 		port:            *port,
 		username:        *username,
 		password:        *password,
+		quarantineList:  *quarantineList,
+		filter:          filter,
+		source:          *source,
+		org:             *org,
+		bucket:          *bucket,
+		token:           *token,
+		lpDir:           *lpDir,
+		lpGzip:          *lpGzip,
+		exportDir:       *exportDir,
+		remoteWriteURL:  *remoteWriteURL,
+		maxRetries:      *maxRetries,
+		tsdbDir:         *tsdbDir,
+		openTSDBURL:     *openTSDBURL,
+		graphiteAddr:    *graphiteAddr,
+		batchSize:       *batchSize,
+		rateLimit:       *rateLimit,
+		wspPath:         *wspPath,
+		dedup:           *dedup,
+		syncState:       *syncState,
+		syncInotify:     *syncInotify,
+		whisperDir:      *whisperDir,
+		ctx:             ctx,
+	}
+	if migrationData.bucket == "NULL" {
+		migrationData.bucket = migrationData.dbName
 	}
 
 	var err error
-	migrationData.from, err = time.Parse("2006-01-02", *from)
+	if *lpSplitSize != "NULL" {
+		migrationData.lpSplitSize, err = ParseSize(*lpSplitSize)
+		if err != nil {
+			log.Fatal("Error in parsing lp-split-size ")
+		}
+	}
+	migrationData.tsdbBlockRange, err = ParseDuration(*tsdbBlockRange)
+	if err != nil || migrationData.tsdbBlockRange < time.Minute {
+		log.Fatal("Error in parsing tsdb-block-duration ")
+	}
+	if *resample != "NULL" {
+		if *option == "Whisper" {
+			log.Fatal("resample can not be used with the Whisper option")
+		}
+		migrationData.resample = &Resample{Interval: *resample,
+			Aggregate: *resampleAgg, XFilesFactor: *resampleXFF}
+		if err := migrationData.resample.Init(); err != nil {
+			log.Fatal("Error in parsing resample ", err)
+		}
+	}
+	migrationData.syncInterval, err = ParseDuration(*syncInterval)
+	if err != nil || migrationData.syncInterval < time.Second {
+		log.Fatal("Error in parsing sync-interval ")
+	}
+	if *defaultTags != "NULL" {
+		migrationData.defaultTags, err = ParseTags(*defaultTags)
+		if err != nil {
+			log.Fatal("Error in parsing default-tags ", err)
+		}
+	}
+	if *option == "Whisper" {
+		if filepath.Clean(*whisperDir) == filepath.Clean(*wspPath) {
+			log.Fatal("whisper-dir must not be the wspPath folder")
+		}
+		aggregationMethod, err := ParseAggregationMethod(*whisperAgg)
+		if err != nil {
+			log.Fatal("Error in parsing whisper-aggregation ", err)
+		}
+		migrationData.whisperSchema, err = ParseArchiveSchema(*whisperSchema,
+			aggregationMethod, float32(*whisperXFF))
+		if err != nil {
+			log.Fatal("Error in parsing whisper-schema ", err)
+		}
+	}
+	progressInterval, err := ParseDuration(*progressEvery)
+	if err != nil || progressInterval < 0 {
+		log.Fatal("Error in parsing progress-interval ")
+	}
+	if *lpSplitDuration != "NULL" {
+		migrationData.lpSplitDuration, err = ParseDuration(*lpSplitDuration)
+		if err != nil || migrationData.lpSplitDuration < time.Second {
+			log.Fatal("Error in parsing lp-split-duration ")
+		}
+	}
+
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		log.Fatal("Error in parsing tz ", err)
+	}
+	now := time.Now()
+	migrationData.from, err = ParseTime(*from, loc, now)
 
 	if err != nil {
-		log.Fatal("Error in parsing from ")
+		log.Fatal("Error in parsing from ", err)
 	}
 
 	if *until != "NULL" {
-		migrationData.until, err = time.Parse("2006-01-02", *until)
+		migrationData.until, err = ParseTime(*until, loc, now)
 		if err != nil {
-			log.Fatal("Error in parsing until ")
+			log.Fatal("Error in parsing until ", err)
 		}
 	} else {
-		migrationData.until = time.Now()
+		migrationData.until = now
+	}
+	if !migrationData.from.Before(migrationData.until) {
+		log.Fatal("from must be before until")
+	}
+	if *timeShift != "NULL" {
+		migrationData.timeShift, err = ParseDuration(*timeShift)
+		if err != nil || migrationData.timeShift%time.Second != 0 {
+			log.Fatal("Error in parsing time-shift ")
+		}
+		if *option == "Whisper" {
+			log.Fatal("time-shift can not be used with the Whisper option")
+		}
 	}
 
-	if err := migrationData.ReadTagConfig(*tagConfigFile); err != nil {
-		fmt.Printf("Error in Parsing the Config file : %s\n", err)
-		return
+	if *metricsAddr != "NULL" {
+		if err := ServeMetrics(*metricsAddr); err != nil {
+			log.Fatal("Error in serving metrics ", err)
+		}
+	}
+
+	if *reportFile != "NULL" {
+		migrationData.report = NewReport(migrationData)
+	}
+	//the report is written also when the migration fails
+	fail := func(msg string, err error) {
+		migrationData.report.Error(fmt.Errorf("%s : %s", msg, err))
+		if err := migrationData.report.Write(*reportFile, migrationData); err != nil {
+			level.Error(logger).Log("msg", "Could not write the report", "err", err)
+		}
+		ExitWithError(msg, err)
 	}
+
+	if *tagConfigFile != "NULL" {
+		if err := migrationData.ReadTagConfig(*tagConfigFile); err != nil {
+			ExitWithError("Error in Parsing the Config file", err)
+		}
+		if migrationData.option == "Whisper" {
+			if err := migrationData.CheckWhisperTagConfig(); err != nil {
+				ExitWithError("Error in Parsing the Config file", err)
+			}
+		}
+	}
+	phaseStart := time.Now()
 	migrationData.FindWhisperFiles(*wspPath)
+	migrationData.report.Phase("scan", phaseStart)
+	phaseStart = time.Now()
+	migrationData.ValidateWhisperFiles()
+	if *skipStale != "NULL" {
+		maxAge, err := ParseDuration(*skipStale)
+		if err != nil {
+			log.Fatal("Error in parsing skip-stale ")
+		}
+		if err := migrationData.SkipStaleFiles(maxAge, *staleReport); err != nil {
+			fail("Error in writing the stale report", err)
+		}
+	}
+	migrationData.report.Phase("validate", phaseStart)
+	migrationData.report.AddFiles(migrationData.wspFiles)
+	if err := migrationData.Canceled(); err != nil {
+		fail("Migration canceled", err)
+	}
 	if len(migrationData.wspFiles) == 0 {
 		fmt.Println("No Whisper files found")
 		return
 	}
-	migrationData.PreviewMTF()
-	//Update the config file
-	migrationData.WriteConfigFile(*tagConfigFile)
+	if migrationData.option == "Whisper" {
+		migrationData.PreviewWhisperFiles()
+	} else {
+		migrationData.PreviewMTF()
+		migrationData.GroupWhisperFilesBySeries()
+		//Update the config file
+		migrationData.WriteConfigFile(*tagConfigFile)
+	}
 	//After the preview, confirm if the user wants to migrate data
 	var userInput string
 	fmt.Println("Do you want to continue the migration? Yes/No :")
-	fmt.Scanf("%s", &userInput)
+	answer := make(chan string, 1)
+	go func() {
+		var input string
+		fmt.Scanf("%s", &input)
+		answer <- input
+	}()
+	select {
+	case userInput = <-answer:
+	case <-ctx.Done():
+	}
 	if strings.ToUpper(userInput) != "YES" {
 		return
 	}
+	if migrationData.dedup != "source" {
+		phaseStart = time.Now()
+		if err := migrationData.LoadExistingSeries(); err != nil {
+			fail("Error in reading the data already in InfluxDB", err)
+		}
+		if err := migrationData.Canceled(); err != nil {
+			fail("Migration canceled", err)
+		}
+		migrationData.report.Phase("existing", phaseStart)
+	}
+	migrationData.progress = NewProgress(progressInterval,
+		len(migrationData.wspFiles), migrationData.whisperFileSize)
+	migrationData.progress.Start()
 	timestart := time.Now()
-	// Create shards for given time ranges
-	if migrationData.option != "TSMW" {
-		migrationData.WriteUsingV2()
-	} else {
-		err := migrationData.CreateShards()
-		if err != nil {
-			fmt.Println(err)
-			return
+	err = migrationData.Migrate()
+	migrationData.progress.Stop()
+	migrationData.DropQuarantined()
+	migrationData.report.Phase("migrate", timestart)
+	if canceled := migrationData.Canceled(); err != nil && canceled != nil {
+		//the summary tells what was migrated before the signal
+		migrationData.PrintSummary(time.Since(timestart).String())
+		fail("Migration canceled", canceled)
+	}
+	if err != nil {
+		fail("Migration failed", err)
+	}
+	timeend := time.Now()
+	migrationData.PrintSummary(timeend.Sub(timestart).String())
+	if err := migrationData.report.Write(*reportFile, migrationData); err != nil {
+		level.Error(logger).Log("msg", "Could not write the report", "err", err)
+	}
+	if *syncMode {
+		if err := migrationData.Sync(*wspPath); err != nil {
+			ExitWithError("Sync failed", err)
+		}
+	}
+}
+
+// Migrate the whisper files with the -option given
+func (migrationData *MigrationData) Migrate() error {
+	switch migrationData.option {
+	case "ClientV2":
+		return migrationData.WriteUsingV2()
+	case "TSMW":
+		// Create shards for given time ranges
+		if err := migrationData.CreateShards(); err != nil {
+			return err
 		}
 		//Map WSP to TSM
-		err = migrationData.MapWSPToTSMByShard()
+		if err := migrationData.MapWSPToTSMByShard(); err != nil {
+			return fmt.Errorf("Mapping Whisper to TSM by Shard failed : %s", err)
+		}
+	case "TSDB":
+		if err := migrationData.WriteTSDBBlocks(); err != nil {
+			return fmt.Errorf("Writing TSDB blocks failed : %s", err)
+		}
+	case "Whisper":
+		if err := migrationData.WriteWhisperFiles(); err != nil {
+			return fmt.Errorf("Writing whisper files failed : %s", err)
+		}
+	default:
+		sink, err := migrationData.NewSink()
 		if err != nil {
-			fmt.Println("Mapping Whisper to TSM by Shard failed : %s\n", err)
-			return
+			return err
+		}
+		if err := migrationData.WriteUsingSink(sink); err != nil {
+			return fmt.Errorf("Migration to %s failed : %s", migrationData.option, err)
 		}
 	}
-	timeend := time.Now()
-	migrationData.PrintSummary(timeend.Sub(timestart).String())
+	return nil
 }
 
 // Read the config file and populate migrartionData.tagConfigs
 func (migrationData *MigrationData) ReadTagConfig(filename string) error {
 	raw, err := ioutil.ReadFile(filename)
 	if err != nil {
-		fmt.Println(err.Error())
-		os.Exit(1)
+		return err
+	}
+	if err := json.Unmarshal(raw, &migrationData.tagConfigs); err != nil {
+		return err
+	}
+	for _, tagConfig := range migrationData.tagConfigs {
+		if !IsValidFieldType(tagConfig.Type) {
+			return fmt.Errorf("Unknown type %s for pattern %s", tagConfig.Type,
+				tagConfig.Pattern)
+		}
+		if IsPatternValue(tagConfig.Field) && (tagConfig.Measurement == "" ||
+			tagConfig.Measurement == tagConfig.Field) {
+			return fmt.Errorf("Pattern %s takes the field from the path and needs another measurement",
+				tagConfig.Pattern)
+		}
+		for _, transform := range tagConfig.Transforms {
+			if err := transform.Validate(); err != nil {
+				return fmt.Errorf("Invalid transform for pattern %s : %s",
+					tagConfig.Pattern, err)
+			}
+		}
+		if tagConfig.Resample != nil {
+			if err := tagConfig.Resample.Init(); err != nil {
+				return fmt.Errorf("Invalid resample for pattern %s : %s",
+					tagConfig.Pattern, err)
+			}
+		}
 	}
-	return json.Unmarshal(raw, &migrationData.tagConfigs)
+	return nil
 }
 
 //Write migrationData.tagConfigs to file
 func (migrationData *MigrationData) WriteConfigFile(filename string) {
 	f, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0666)
 	if err != nil {
-		fmt.Println("File Open Error")
+		level.Error(logger).Log("msg", "Could not open the config file",
+			"file", filename, "err", err)
 		return
 	}
 	configStr, _ := json.MarshalIndent(migrationData.tagConfigs, "", "  ")
 	_, err = f.WriteString(string(configStr))
 	if err != nil {
-		fmt.Println("Write Error")
+		level.Error(logger).Log("msg", "Could not write the config file",
+			"file", filename, "err", err)
 		return
 	}
 	f.Close()
@@ -283,24 +755,94 @@ func NewConfig(wspFile string) *TagConfig {
 	}
 }
 
-// Find all whisper files from a given wspPath
+// Find all whisper files from a given wspPath, or from the -files-from list,
+// which pass the include/exclude and mtime filters
 func (migrationData *MigrationData) FindWhisperFiles(searchDir string) {
+	if migrationData.source == "ceres" {
+		migrationData.FindCeresNodes(searchDir)
+		return
+	}
+	filter := migrationData.filter
+	if filter == nil {
+		filter = &FileFilter{}
+	}
+	found := func(path string, f os.FileInfo) {
+		if !filter.MatchName(MetricName(searchDir, path)) ||
+			!filter.MatchMtime(f.ModTime()) {
+			return
+		}
+		migrationData.AddWhisperFile(path, f.Size())
+	}
 
-	fileList := []string{}
-	err := filepath.Walk(searchDir, func(path string, f os.FileInfo, err error) error {
-		if os.IsNotExist(err) { //search dir does not exist
-			return nil
+	if filter.filesFrom != "NULL" && filter.filesFrom != "" {
+		paths, err := ReadFileList(filter.filesFrom)
+		if err != nil {
+			level.Error(logger).Log("msg", "Could not read file list", "phase", "scan",
+				"err", err)
 		}
-		if strings.HasSuffix(f.Name(), "wsp") {
-			fileList = append(fileList, path)
-			migrationData.whisperFileSize = migrationData.whisperFileSize + f.Size()
+		for _, path := range paths {
+			f, err := os.Stat(path)
+			if err != nil || f.IsDir() {
+				level.Warn(logger).Log("msg", "Skipping, not a whisper file",
+					"phase", "scan", "file", path)
+				continue
+			}
+			found(path, f)
 		}
-		return nil
-	})
-	if err != nil {
-		fmt.Println("Err")
+	} else if IsTarArchive(searchDir) {
+		if err := migrationData.FindArchiveWhisperFiles(searchDir); err != nil {
+			level.Error(logger).Log("msg", "Could not read whisper archive",
+				"phase", "scan", "err", err)
+		}
+	} else if err := filter.walk(searchDir, map[string]bool{}, IsWhisperFileName,
+		found); err != nil &&
+		!os.IsNotExist(err) { //search dir does not exist
+		level.Error(logger).Log("msg", "Could not read whisper folder",
+			"phase", "scan", "err", err)
+	}
+}
+
+// Add a whisper file found to the files to be migrated
+func (migrationData *MigrationData) AddWhisperFile(wspFile string, size int64) {
+	if migrationData.wspFileSizes == nil {
+		migrationData.wspFileSizes = make(map[string]int64)
 	}
-	migrationData.wspFiles = fileList
+	migrationData.wspFiles = append(migrationData.wspFiles, wspFile)
+	migrationData.wspFileSizes[wspFile] = size
+	migrationData.whisperFileSize = migrationData.whisperFileSize + size
+}
+
+// Get measurement, tags and field for a whisper file, prompting the user for a
+// new pattern when none of the configured patterns match
+func (migrationData *MigrationData) LookupMTF(wspFile string) *MTF {
+	mtf := migrationData.GetMTF(wspFile)
+	if mtf == nil {
+		//Create and add the pattern
+		var tagConfig *TagConfig
+		for {
+			tagConfig = NewConfig(wspFile)
+			if tagConfig != nil {
+				break
+			}
+		}
+		migrationData.tagConfigs = append(migrationData.tagConfigs, *tagConfig)
+		mtf = &MTF{Measurement: tagConfig.Measurement, Tags: tagConfig.Tags,
+			Field: tagConfig.Field, Type: tagConfig.Type,
+			Transforms: tagConfig.Transforms, Resample: tagConfig.Resample}
+	}
+	migrationData.ResolveTags(wspFile, mtf)
+	if mtf.Resample == nil {
+		mtf.Resample = migrationData.resample
+	}
+	return mtf
+}
+
+// Resampling of the series of a whisper file, nil if it is not resampled
+func (migrationData *MigrationData) ResampleOf(wspFile string) *Resample {
+	if mtf := migrationData.GetMTF(wspFile); mtf != nil && mtf.Resample != nil {
+		return mtf.Resample
+	}
+	return migrationData.resample
 }
 
 // Gives a preview how the measurements, tags and fields look like for given
@@ -308,23 +850,15 @@ func (migrationData *MigrationData) FindWhisperFiles(searchDir string) {
 // not exist already for a given pattern
 func (migrationData *MigrationData) PreviewMTF() {
 	for _, wspFile := range migrationData.wspFiles {
-		var tagConfig *TagConfig
-		var mtf *MTF
-		if mtf = migrationData.GetMTF(wspFile); mtf != nil {
-		} else { //Create and add the pattern
-			for {
-				tagConfig = NewConfig(wspFile)
-				if tagConfig != nil {
-					break
-				}
-			}
-			migrationData.tagConfigs = append(migrationData.tagConfigs, *tagConfig)
-
-			mtf = &MTF{Measurement: tagConfig.Measurement, Tags: tagConfig.Tags,
-				Field: tagConfig.Field}
-		}
+		mtf := migrationData.LookupMTF(wspFile)
 		key := CreateTSMKey(mtf)
 		fmt.Println("\nWhisper File", wspFile, "\nTSM Key->", key)
+		if len(mtf.Transforms) > 0 {
+			fmt.Println("Transforms->", FormatTransforms(mtf.Transforms))
+		}
+		if mtf.Resample != nil {
+			fmt.Println("Resample->", mtf.Resample)
+		}
 	}
 }
 
@@ -337,57 +871,82 @@ func (migrationData *MigrationData) PreviewMTF() {
 */
 
 func (migrationData *MigrationData) CreateShards() error {
-	c, _ := client.NewHTTPClient(client.HTTPConfig{
+	c, err := client.NewHTTPClient(client.HTTPConfig{
 		Addr: migrationData.host + ":" + migrationData.port,
 	})
+	if err != nil {
+		return fmt.Errorf("Error in connecting to InfluxDB : %s", err)
+	}
 	defer c.Close()
 
 	createDBString := fmt.Sprintf("Create Database %v", migrationData.dbName)
 	createDBQuery := client.NewQuery(createDBString, "", "")
-	_, err := c.Query(createDBQuery)
+	_, err = c.Query(createDBQuery)
 	if err != nil {
 		return fmt.Errorf("Error while creating Database : %s\n", err)
 	}
 
 	// Create a new point batch
-	bp, _ := client.NewBatchPoints(client.BatchPointsConfig{
+	bp, err := client.NewBatchPoints(client.BatchPointsConfig{
 		Database:  migrationData.dbName,
 		Precision: "s",
 	})
+	if err != nil {
+		return err
+	}
 
 	// Create a point and add to batch
 	tags := map[string]string{"tag1": "value1"}
 	fields := map[string]interface{}{
 		"value": 10.1,
 	}
-	//Create and parse
-	for i := migrationData.from; i.Before(migrationData.until); i = i.Add(time.Duration(24) * time.Hour) {
-		pt, _ := client.NewPoint("dummy", tags, fields, i)
+	//Create and parse, shards are needed for the time range after time-shift
+	from := migrationData.from.Add(migrationData.timeShift)
+	until := migrationData.until.Add(migrationData.timeShift)
+	for i := from; i.Before(until); i = i.Add(time.Duration(24) * time.Hour) {
+		pt, err := client.NewPoint("dummy", tags, fields, i)
+		if err != nil {
+			return err
+		}
 		bp.AddPoint(pt)
 	}
 	// Write the batch
-	c.Write(bp)
+	if err := c.Write(bp); err != nil {
+		return fmt.Errorf("Error in writing points to create shards : %s", err)
+	}
 
 	query := client.NewQuery("Show Shard Groups", "", "")
 	response, err := c.Query(query)
+	if err == nil {
+		err = response.Error()
+	}
 	if err != nil {
 		return fmt.Errorf("Error in Querying : %s\n", err)
 	}
-	var index int = 0
-	var colname string
-	for index, colname = range response.Results[0].Series[0].Columns {
-		if colname == "database" {
-			break
+	if len(response.Results) == 0 || len(response.Results[0].Series) == 0 {
+		return fmt.Errorf("Error in Querying : no shard groups returned")
+	}
+	series := response.Results[0].Series[0]
+	columns := make(map[string]int)
+	for index, colname := range series.Columns {
+		columns[colname] = index
+	}
+	for _, colname := range []string{"id", "database", "start_time", "end_time"} {
+		if _, ok := columns[colname]; !ok {
+			return fmt.Errorf("Error in Querying : no %s column in shard groups", colname)
 		}
 	}
-	for _, values := range response.Results[0].Series[0].Values {
-		if values[index] == migrationData.dbName {
-			shard := &ShardInfo{}
-			shard.id = values[0].(json.Number)
-			shard.from, _ = time.Parse(time.RFC3339, values[3].(string))
-			shard.until, _ = time.Parse(time.RFC3339, values[4].(string))
-			migrationData.shards = append(migrationData.shards, *shard)
+	for _, values := range series.Values {
+		if len(values) != len(series.Columns) ||
+			values[columns["database"]] != migrationData.dbName {
+			continue
+		}
+		shard, err := ParseShardGroup(values[columns["id"]],
+			values[columns["start_time"]], values[columns["end_time"]])
+		if err != nil {
+			return fmt.Errorf("Error in reading shard groups : %s", err)
 		}
+		migrationData.shards = append(migrationData.shards, shard)
 	}
 
 	//Once shards are created, this measurement is not required
@@ -399,28 +958,70 @@ func (migrationData *MigrationData) CreateShards() error {
 	return nil
 }
 
+// A shard group from the id, start_time and end_time of SHOW SHARD GROUPS
+func ParseShardGroup(id interface{}, start interface{}, end interface{}) (ShardInfo, error) {
+	var shard ShardInfo
+	var ok bool
+	var err error
+	if shard.id, ok = id.(json.Number); !ok {
+		return shard, fmt.Errorf("invalid shard group id %v", id)
+	}
+	if shard.from, err = parseShardTime(start); err != nil {
+		return shard, err
+	}
+	shard.until, err = parseShardTime(end)
+	return shard, err
+}
+
+func parseShardTime(value interface{}) (time.Time, error) {
+	s, ok := value.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid shard group time %v", value)
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
 // For every shard, gets the whisper data which overlaps the time range of shard
 // and  Writes to the respective TSM file
 func (migrationData *MigrationData) MapWSPToTSMByShard() error {
 	var from, until time.Time
+	migrationData.progress.SetPasses(len(migrationData.shards))
 	for _, shard := range migrationData.shards {
-		from = shard.from
-		if shard.from.Before(migrationData.from) && shard.until.After(migrationData.from) {
+		if err := migrationData.Canceled(); err != nil {
+			return err
+		}
+		//the whisper data of a shard is the one before time-shift
+		from = shard.from.Add(-migrationData.timeShift)
+		shardUntil := shard.until.Add(-migrationData.timeShift)
+		if from.Before(migrationData.from) && shardUntil.After(migrationData.from) {
 			from = migrationData.from
 		}
-		until = shard.until
-		if shard.until.After(migrationData.until) {
+		until = shardUntil
+		if shardUntil.After(migrationData.until) {
 			until = migrationData.until
 		}
-		ch := make(chan []TsmPoint)
-		go func() {
-			ch <- migrationData.MapWSPToTSMByWhisperFile(from, until)
-		}()
+		level.Info(logger).Log("msg", "Migrating shard", "phase", "read",
+			"shard", shard.id, "from", from, "until", until)
+		SetCurrentShard(shard.id.String())
+		phaseStart := time.Now()
+		tsmPoints, err := migrationData.MapWSPToTSMByWhisperFile(from, until)
+		migrationData.report.Phase("read", phaseStart)
+		if err != nil {
+			return fmt.Errorf("Error in reading whisper files for shard %s : %s",
+				shard.id, err)
+		}
 		//Write the TSM data
+		phaseStart = time.Now()
 		filename := migrationData.GetTSMFileName(shard)
-		err := migrationData.WriteTSMPoints(filename, <-ch)
-		if err != nil {
-			return fmt.Errorf("Error in TSM Writing")
+		tsmFileSize := migrationData.tsmFileSize
+		if err := migrationData.WriteTSMPoints(filename, tsmPoints); err != nil {
+			return fmt.Errorf("Error in TSM Writing shard %s : %s", shard.id, err)
+		}
+		migrationData.report.AllWritten()
+		migrationData.report.Phase("write", phaseStart)
+		if migrationData.tsmFileSize > tsmFileSize {
+			migrationData.report.Shard(shard, filename,
+				migrationData.tsmFileSize-tsmFileSize)
 		}
 	}
 	return nil
@@ -429,52 +1030,135 @@ func (migrationData *MigrationData) MapWSPToTSMByShard() error {
 //For every whisper file, maps whisper data points to TSM data points for
 //given time range, this is just mapping points from one Data structure to other
 // not writing to files
-func (migrationData *MigrationData) MapWSPToTSMByWhisperFile(from time.Time, until time.Time) []TsmPoint {
+func (migrationData *MigrationData) MapWSPToTSMByWhisperFile(from time.Time,
+	until time.Time) ([]TsmPoint, error) {
 	var tsmPoints []TsmPoint
 	var tsmPoint TsmPoint
 
-	for _, wspFile := range migrationData.wspFiles {
-		w, err := whisper.Open(wspFile)
-		fmt.Println("Migrating Data From ", wspFile, "For TimeRange ", from, until, "Size", w.Header.Archives[0].Size())
-		if err != nil {
-			log.Fatal(err)
-		}
+	err := migrationData.FetchAllPoints(from, until,
+		func(wspFile string, wspPoints []whisper.Point) error {
+			if len(wspPoints) == 0 {
+				return nil
+			}
+			mtf, wspPoints := migrationData.MapPoints(wspFile, wspPoints)
 
-		//the first argument is interval, since it's not required for migration
-		//using _
-		_, wspPoints, err := w.FetchUntilTime(from, until)
-		w.Close()
-		if err != nil {
-			log.Fatal(err)
-		}
+			tsmPoint.key = CreateTSMKey(mtf)
+			tsmPoint.values = CreateTSMValues(mtf, wspPoints)
+			tsmPoints = append(tsmPoints, tsmPoint)
+			level.Debug(logger).Log("msg", "Series mapped", "phase", "map",
+				"file", wspFile, "series", tsmPoint.key, "points", len(wspPoints))
+			return nil
+		})
+	return tsmPoints, err
+}
 
-		if len(wspPoints) == 0 {
-			continue
+// Calls fn with the points of every whisper file, or Ceres node, for the given
+// time range. Files which can not be read are quarantined and skipped
+func (migrationData *MigrationData) FetchAllPoints(from time.Time, until time.Time,
+	fn func(wspFile string, wspPoints []whisper.Point) error) error {
+
+	//a resampled series is read in whole buckets, fn gets the points of the
+	//buckets starting in from, until only
+	fetchRange := func(wspFile string) (time.Time, time.Time) {
+		if resample := migrationData.ResampleOf(wspFile); resample != nil {
+			return resample.FetchRange(from, until)
 		}
-		var tagConfig *TagConfig
-		var mtf *MTF
-		if mtf = migrationData.GetMTF(wspFile); mtf != nil {
-		} else { //Create and add the pattern
-			for {
-				tagConfig = NewConfig(wspFile)
-				if tagConfig != nil {
-					break
-				}
+		return from, until
+	}
+	mapped := func(wspFile string, wspPoints []whisper.Point) error {
+		if resample := migrationData.ResampleOf(wspFile); resample != nil {
+			wspPoints = resample.WindowPoints(wspPoints, from, until)
+		}
+		return fn(wspFile, wspPoints)
+	}
+	if migrationData.source == "ceres" {
+		for _, node := range migrationData.wspFiles {
+			if err := migrationData.Canceled(); err != nil {
+				return err
+			}
+			if migrationData.IsQuarantined(node) {
+				continue
+			}
+			nodeFrom, nodeUntil := fetchRange(node)
+			migrationData.progress.Reading(node, "from", nodeFrom, "until", nodeUntil)
+			points, err := FetchCeresPoints(node, nodeFrom, nodeUntil)
+			migrationData.FileRead(migrationData.wspFileSizes[node])
+			if err != nil {
+				migrationData.Quarantine(node, err)
+				continue
+			}
+			pointsRead.Add(float64(len(points)))
+			if err := mapped(node, points); err != nil {
+				return err
 			}
-			migrationData.tagConfigs = append(migrationData.tagConfigs, *tagConfig)
-
-			mtf = &MTF{Measurement: tagConfig.Measurement, Tags: tagConfig.Tags,
-				Field: tagConfig.Field}
 		}
+		return nil
+	}
+	if migrationData.wspArchive == "" {
+		for _, wspFile := range migrationData.wspFiles {
+			if err := migrationData.Canceled(); err != nil {
+				return err
+			}
+			if migrationData.IsQuarantined(wspFile) {
+				continue
+			}
+			fileFrom, fileUntil := fetchRange(wspFile)
+			wspPoints, err := FetchWhisperPoints(wspFile, fileFrom, fileUntil,
+				migrationData.progress)
+			migrationData.FileRead(migrationData.wspFileSizes[wspFile])
+			if err != nil {
+				migrationData.Quarantine(wspFile, err)
+				continue
+			}
+			pointsRead.Add(float64(len(wspPoints)))
+			if err := mapped(wspFile, wspPoints); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 
-		tsmPoint.key = CreateTSMKey(mtf)
-		tsmPoint.values = make([]tsm1.Value, len(wspPoints))
-		for j, wspPoint := range wspPoints {
-			tsmPoint.values[j] = tsm1.NewValue(int64(wspPoint.Timestamp), wspPoint.Value)
+	//whisper.Open needs a file on disk, entries of an archive are decoded from
+	//memory instead
+	now := time.Now()
+	return migrationData.EachWhisperFile(func(wspFile string, r io.ReaderAt, size int64) error {
+		migrationData.FileRead(size)
+		header, err := ReadWhisperHeader(r)
+		if err != nil {
+			migrationData.Quarantine(wspFile, err)
+			return nil
 		}
-		tsmPoints = append(tsmPoints, tsmPoint)
+		fileFrom, fileUntil := fetchRange(wspFile)
+		migrationData.progress.Reading(wspFile, "from", fileFrom, "until", fileUntil,
+			"size", header.Archives[0].Size())
+		wspPoints, err := header.FetchPoints(r, fileFrom, fileUntil, now)
+		if err != nil {
+			migrationData.Quarantine(wspFile, err)
+			return nil
+		}
+		pointsRead.Add(float64(len(wspPoints)))
+		return mapped(wspFile, wspPoints)
+	})
+}
+
+// Reads the whisper points of a single file for the given time range
+func FetchWhisperPoints(wspFile string, from time.Time,
+	until time.Time, progress *Progress) ([]whisper.Point, error) {
+
+	w, err := whisper.Open(wspFile)
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+	if len(w.Header.Archives) == 0 {
+		return nil, fmt.Errorf("no archives in header")
 	}
-	return tsmPoints
+	progress.Reading(wspFile, "from", from, "until", until,
+		"size", w.Header.Archives[0].Size())
+	//the first return argument is interval, since it's not required for migration
+	//using _
+	_, wspPoints, err := w.FetchUntilTime(from, until)
+	return wspPoints, err
 }
 
 func (migrationData *MigrationData) GetTSMFileName(shard ShardInfo) string {
@@ -486,7 +1170,10 @@ func (migrationData *MigrationData) GetTSMFileName(shard ShardInfo) string {
 	return filePath
 }
 
-// Write TSMPoints data to TSM files
+// Write TSMPoints data to TSM files. The points are written to a .tmp file
+// which is renamed to filename once complete, so that an interrupted write
+// never leaves a partial TSM file in the shard. The write is finished when the
+// migration is canceled meanwhile, the points are mapped already
 func (migrationData *MigrationData) WriteTSMPoints(filename string,
 	tsmPoints []TsmPoint) error {
 
@@ -494,27 +1181,36 @@ func (migrationData *MigrationData) WriteTSMPoints(filename string,
 		return nil
 	}
 	// Open tsm file for writing
-	f, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0666)
+	tmpFilename := filename + ".tmp"
+	f, err := os.Create(tmpFilename)
 	if err != nil {
-		fmt.Println(err)
 		return err
 	}
 	defer f.Close()
+	written := false
+	defer func() {
+		if !written {
+			os.Remove(tmpFilename)
+		}
+	}()
 
 	//Create TSMWriter with filehandle
 	tsmWriter, err := tsm1.NewTSMWriter(f)
 	if err != nil {
-		panic(fmt.Sprintf("create TSM writer: %v", err))
+		return fmt.Errorf("Error in creating TSM writer : %s", err)
 	}
 
 	//Write the points in batch
 	writes := 0
 	for _, tsmPoint := range tsmPoints {
-		//fmt.Println(i, tsmPoint.key)
 		if len(tsmPoint.values) > 0 {
+			start := time.Now()
 			if err := tsmWriter.Write(tsmPoint.key, tsmPoint.values); err != nil {
-				panic(fmt.Sprintf("write TSM value: %v", err))
+				return fmt.Errorf("Error in writing TSM values of %s : %s",
+					tsmPoint.key, err)
 			}
+			migrationData.progress.PointsWritten(len(tsmPoint.values))
+			ObserveWrite("TSMW", start, len(tsmPoint.values))
 			writes = writes + 1
 		}
 	}
@@ -524,29 +1220,45 @@ func (migrationData *MigrationData) WriteTSMPoints(filename string,
 	}
 	//Write index
 	if err := tsmWriter.WriteIndex(); err != nil {
-		panic(fmt.Sprintf("write TSM index: %v", err))
+		return fmt.Errorf("Error in writing TSM index : %s", err)
 	}
 
 	if err := tsmWriter.Close(); err != nil {
-		panic(fmt.Sprintf("write TSM close: %v", err))
+		return fmt.Errorf("Error in closing TSM writer : %s", err)
+	}
+	if err := os.Rename(tmpFilename, filename); err != nil {
+		return err
 	}
+	written = true
 
 	// Opening the file again, just to check size
 	f1, err := os.OpenFile(filename, os.O_RDWR, 0666)
 	if err != nil {
-		fmt.Println(err)
 		return err
 	}
 	defer f1.Close()
 
 	fileinfo1, err := f1.Stat()
 	if err != nil {
-		fmt.Println("Could not read filestat", err)
-		return err
+		return fmt.Errorf("Could not read filestat : %s", err)
 	}
 	migrationData.tsmFileSize = migrationData.tsmFileSize + fileinfo1.Size()
-	fmt.Println("TSM File ", filename, "Size ", fileinfo1.Size())
-	return err
+	level.Info(logger).Log("msg", "TSM file written", "phase", "write",
+		"file", filename, "size", fileinfo1.Size())
+	return nil
+}
+
+// Convert whisper points to TSM values. Whisper timestamps are in seconds,
+// TSM timestamps in nanoseconds like every InfluxDB timestamp, written as
+// seconds the points would all land in the first seconds of 1970
+func CreateTSMValues(mtf *MTF, wspPoints []whisper.Point) []tsm1.Value {
+	values := make([]tsm1.Value, len(wspPoints))
+	for j, wspPoint := range wspPoints {
+		value, _ := InfluxDBValue(mtf.Type, wspPoint.Value)
+		values[j] = tsm1.NewValue(
+			time.Unix(int64(wspPoint.Timestamp), 0).UnixNano(), value)
+	}
+	return values
 }
 
 //Create TSM Key from measurement, tags and field
@@ -603,11 +1315,18 @@ func (migrationData *MigrationData) GetMTF(wspFilename string) *MTF {
 	//start at i=1, that's #TEXT1 and iterate on all possible # strings in given
 	// pattern
 	mtf.Tags = make([]TagKeyValue, len(tagConfig.Tags))
+	for j, tagkeyvalue := range tagConfig.Tags {
+		//literal and $derived tag values are taken as they are
+		if !IsPatternValue(tagkeyvalue.Tagvalue) {
+			mtf.Tags[j] = tagkeyvalue
+		}
+	}
 	for i := 1; i < len(patternStr)-1; i++ {
 		patternTagValue := strings.Trim(patternStr[i], ".")
 		//For each # string, find a match in tag values
 		for j, tagkeyvalue := range tagConfig.Tags {
-			if strings.Trim(tagkeyvalue.Tagvalue, "#") == patternTagValue {
+			if IsPatternValue(tagkeyvalue.Tagvalue) &&
+				strings.Trim(tagkeyvalue.Tagvalue, "#") == patternTagValue {
 				mtf.Tags[j].Tagkey = tagkeyvalue.Tagkey
 				//Tag #value is replaced with the actual value
 				mtf.Tags[j].Tagvalue = remArr[i-1]
@@ -617,6 +1336,18 @@ func (migrationData *MigrationData) GetMTF(wspFilename string) *MTF {
 	// Assign the last string as measurement
 	mtf.Measurement = remArr[len(remArr)-1]
 	mtf.Field = tagConfig.Field
+	//A #value field names the field after a part of the path, the whisper
+	//files of e.g. cpu.user and cpu.system become fields of one measurement
+	if IsPatternValue(tagConfig.Field) {
+		mtf.Field = PatternValue(patternStr, remArr, tagConfig.Field)
+		mtf.Measurement = tagConfig.Measurement
+		if IsPatternValue(tagConfig.Measurement) {
+			mtf.Measurement = PatternValue(patternStr, remArr, tagConfig.Measurement)
+		}
+	}
+	mtf.Type = tagConfig.Type
+	mtf.Transforms = tagConfig.Transforms
+	mtf.Resample = tagConfig.Resample
 	return &mtf
 }
 
@@ -625,7 +1356,19 @@ func (migrationData *MigrationData) PrintSummary(duration string) {
 	fmt.Printf("|------------------------------------|\n")
 	fmt.Printf("|------Migration Summary-------------|\n")
 	fmt.Printf("|------------------------------------|\n")
-	fmt.Printf("| No. of whisper files migrated %d|\n", len(migrationData.wspFiles))
+	if migrationData.Canceled() != nil {
+		fmt.Printf("| Migration canceled after %d whisper file reads|\n", migrationData.filesRead)
+	} else {
+		fmt.Printf("| No. of whisper files migrated %d|\n", len(migrationData.wspFiles))
+	}
+	fmt.Printf("| No. of whisper files quarantined %d|\n", len(migrationData.quarantined))
+	fmt.Printf("| No. of stale whisper files skipped %d|\n", migrationData.staleFiles)
+	if migrationData.dedupedPoints > 0 {
+		fmt.Printf("| No. of points already in InfluxDB %d|\n", migrationData.dedupedPoints)
+	}
+	if migrationData.rejectedPoints > 0 {
+		fmt.Printf("| No. of points not matching the type %d|\n", migrationData.rejectedPoints)
+	}
 	fmt.Printf("| TimeTaken %v |\n", duration)
 	size, unit := formatSize(migrationData.whisperFileSize)
 	fmt.Printf("| Total Whisper File Size %.2f %s |\n", size, unit)
@@ -639,80 +1382,64 @@ func (migrationData *MigrationData) PrintSummary(duration string) {
 	fmt.Printf("|------------------------------------|\n")
 }
 
-func (migrationData *MigrationData) WriteUsingV2() {
+func (migrationData *MigrationData) WriteUsingV2() error {
 	from := migrationData.from
 	until := migrationData.until
-	c, _ := client.NewHTTPClient(client.HTTPConfig{
+	c, err := client.NewHTTPClient(client.HTTPConfig{
 		Addr:     migrationData.host + ":" + migrationData.port,
 		Username: migrationData.username,
 		Password: migrationData.password,
 	})
+	if err != nil {
+		return fmt.Errorf("Error in connecting to InfluxDB : %s", err)
+	}
 	defer c.Close()
 
 	createDBString := fmt.Sprintf("Create Database %v", migrationData.dbName)
 	createDBQuery := client.NewQuery(createDBString, "", "")
-	_, err := c.Query(createDBQuery)
+	_, err = c.Query(createDBQuery)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return fmt.Errorf("Error while creating Database : %s", err)
 	}
 
-	var bp client.BatchPoints
-	bp, _ = client.NewBatchPoints(client.BatchPointsConfig{
-		Database:  migrationData.dbName,
-		Precision: "s",
-	})
+	//Every series is one batch, with the fields of all its whisper files
+	err = migrationData.FetchAllSeries(from, until,
+		func(mtf *MTF, points []FieldsPoint) error {
+			bp, err := client.NewBatchPoints(client.BatchPointsConfig{
+				Database:  migrationData.dbName,
+				Precision: "s",
+			})
+			if err != nil {
+				return err
+			}
 
-	for _, wspFile := range migrationData.wspFiles {
-		w, err := whisper.Open(wspFile)
-		if err != nil {
-			log.Fatal(err)
-		}
-		fmt.Println("Migrating Data From ", wspFile, "For TimeRange ", from, until, "Size", w.Header.Archives[0].Size())
-		//the first return argument is interval, since it's not required for migration
-		//using _
-		_, wspPoints, err := w.FetchUntilTime(from, until)
-		w.Close()
-		if err != nil {
-			log.Fatal(err)
-		}
+			var tags map[string]string
+			tags = make(map[string]string)
+			for _, tagConfigTag := range mtf.Tags {
+				tags[tagConfigTag.Tagkey] = tagConfigTag.Tagvalue
+			}
 
-		if len(wspPoints) == 0 {
-			continue
-		}
-		var tagConfig *TagConfig
-		var mtf *MTF
-		if mtf = migrationData.GetMTF(wspFile); mtf != nil {
-		} else { //Create and add the pattern
-			for {
-				tagConfig = NewConfig(wspFile)
-				if tagConfig != nil {
-					break
+			for _, point := range points {
+				pt, err := client.NewPoint(mtf.Measurement, tags, point.Fields,
+					time.Unix(int64(point.Timestamp), 0))
+				if err != nil {
+					return fmt.Errorf("Error in creating point of %s : %s",
+						CreateSeriesKey(mtf), err)
 				}
+				bp.AddPoint(pt)
 			}
-			migrationData.tagConfigs = append(migrationData.tagConfigs, *tagConfig)
-
-			mtf = &MTF{Measurement: tagConfig.Measurement, Tags: tagConfig.Tags,
-				Field: tagConfig.Field}
-		}
-
-		var tags map[string]string
-		tags = make(map[string]string)
-		for _, tagConfigTag := range mtf.Tags {
-			tags[tagConfigTag.Tagkey] = tagConfigTag.Tagvalue
-		}
-		var fields map[string]interface{}
-		fields = make(map[string]interface{})
-
-		for _, wspPoint := range wspPoints {
-			fields[mtf.Field] = wspPoint.Value
-			pt, _ := client.NewPoint(mtf.Measurement, tags, fields,
-				time.Unix(int64(wspPoint.Timestamp), 0))
-			bp.AddPoint(pt)
-		}
-		c.Write(bp)
-	}
-	return
+			start := time.Now()
+			if err := c.Write(bp); err != nil {
+				migrationErrors.WithLabelValues("write").Inc()
+				return fmt.Errorf("Error in writing %s : %s", CreateSeriesKey(mtf), err)
+			}
+			migrationData.progress.PointsWritten(len(points))
+			ObserveWrite("ClientV2", start, len(points))
+			level.Debug(logger).Log("msg", "Series written", "phase", "write",
+				"series", CreateSeriesKey(mtf), "points", len(points))
+			return nil
+		})
+	return err
 }
 
 func formatSize(size int64) (float64, string) {
@@ -729,6 +1456,12 @@ func formatSize(size int64) (float64, string) {
 }
 
 func (migrationData *MigrationData) GetWhisperInfo() error {
+	if migrationData.wspArchive != "" {
+		return migrationData.GetArchiveWhisperInfo()
+	}
+	if migrationData.source == "ceres" {
+		return migrationData.GetCeresInfo()
+	}
 	for _, wspFile := range migrationData.wspFiles {
 		w, err := whisper.Open(wspFile)
 		if err != nil {
@@ -750,3 +1483,24 @@ func (migrationData *MigrationData) GetWhisperInfo() error {
 	}
 	return nil
 }
+
+// Same as GetWhisperInfo for the entries of a tar archive
+func (migrationData *MigrationData) GetArchiveWhisperInfo() error {
+	now := time.Now()
+	return migrationData.EachWhisperFile(func(wspFile string, r io.ReaderAt, size int64) error {
+		header, err := ReadWhisperHeader(r)
+		if err != nil {
+			return err
+		}
+		oldest := now.Add(-time.Duration(header.MaxRetention) * time.Second)
+		pts, err := header.FetchPoints(r, oldest, now, now)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Whisper File : %s\n", wspFile)
+		fmt.Println("Oldest Data in File : ", oldest.Truncate(time.Second))
+		fmt.Println("Number of whisper points : ", len(pts))
+		fmt.Println("-----------------------------------------------------------------------")
+		return nil
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+)
+
+func TestParseShardGroup(t *testing.T) {
+	tests := []struct {
+		id    interface{}
+		start interface{}
+		end   interface{}
+		err   bool
+	}{
+		{json.Number("3"), "2015-11-02T00:00:00Z", "2015-11-09T00:00:00Z", false},
+		{"3", "2015-11-02T00:00:00Z", "2015-11-09T00:00:00Z", true},
+		{json.Number("3"), nil, "2015-11-09T00:00:00Z", true},
+		{json.Number("3"), "2015-11-02T00:00:00Z", "next week", true},
+	}
+	for _, test := range tests {
+		shard, err := ParseShardGroup(test.id, test.start, test.end)
+		if (err != nil) != test.err {
+			t.Errorf("ParseShardGroup(%v, %v, %v) err %v", test.id, test.start, test.end, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if shard.id != "3" || !shard.from.Equal(time.Date(2015, 11, 2, 0, 0, 0, 0, time.UTC)) ||
+			!shard.until.Equal(time.Date(2015, 11, 9, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("shard %+v", shard)
+		}
+	}
+}
+
+func TestWriteTSMPoints(t *testing.T) {
+	mtf := &MTF{Measurement: "cpu", Tags: []TagKeyValue{{"host", "a"}},
+		Field: "value", Type: "integer"}
+	filename := filepath.Join(t.TempDir(), "000000001-000000001.tsm")
+	migrationData := &MigrationData{}
+	err := migrationData.WriteTSMPoints(filename, []TsmPoint{{key: CreateTSMKey(mtf),
+		values: CreateTSMValues(mtf, []whisper.Point{{Timestamp: 1446422400, Value: 3}})}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := tsm1.NewTSMReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if key, _ := reader.KeyAt(0); reader.KeyCount() != 1 || key != "cpu,host=a#!~#value" {
+		t.Fatalf("keys %d, key %q", reader.KeyCount(), key)
+	}
+	values, err := reader.ReadAll("cpu,host=a#!~#value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//Whisper seconds are written as nanoseconds
+	want := time.Date(2015, 11, 2, 0, 0, 0, 0, time.UTC).UnixNano()
+	if len(values) != 1 || values[0].UnixNano() != want || values[0].Value() != int64(3) {
+		t.Errorf("values %v", values)
+	}
+}
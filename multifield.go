@@ -0,0 +1,120 @@
+package main
+
+import (
+	"github.com/uttamgandhi24/whisper-go/whisper"
+	"sort"
+	"strings"
+	"time"
+)
+
+func IsPatternValue(value string) bool {
+	return strings.HasPrefix(value, "#")
+}
+
+// Part of the metric path matching the #value of a pattern. The last #value
+// of a pattern matches the last part of the path, as for the measurement
+func PatternValue(patternStr []string, remArr []string, value string) string {
+	name := strings.Trim(value, "#")
+	for i := 1; i < len(patternStr); i++ {
+		if strings.Trim(patternStr[i], ".") != name {
+			continue
+		}
+		if i == len(patternStr)-1 {
+			return remArr[len(remArr)-1]
+		}
+		if i-1 < len(remArr) {
+			return remArr[i-1]
+		}
+	}
+	return value
+}
+
+// Series key of the measurement and tags, without the field
+func CreateSeriesKey(mtf *MTF) string {
+	return strings.SplitN(CreateTSMKey(mtf), "#!~#", 2)[0]
+}
+
+// Order the whisper files by series, so that the files holding the fields of
+// one series are read one after another and can be merged into points with
+// all the fields. Tar archives are read in archive order, where the files of
+// one series are adjacent when they are in the same folder
+func (migrationData *MigrationData) GroupWhisperFilesBySeries() {
+	keys := make(map[string]string, len(migrationData.wspFiles))
+	for _, wspFile := range migrationData.wspFiles {
+		keys[wspFile] = CreateSeriesKey(migrationData.LookupMTF(wspFile))
+	}
+	sort.SliceStable(migrationData.wspFiles, func(i, j int) bool {
+		return keys[migrationData.wspFiles[i]] < keys[migrationData.wspFiles[j]]
+	})
+}
+
+// Fields of a series at one timestamp
+type FieldsPoint struct {
+	Timestamp uint32
+	Fields    map[string]interface{}
+}
+
+// Calls fn once per series with the points of all the whisper files mapping
+// to the series, merged by timestamp. Whisper files of a series which are not
+// read one after another are passed as separate series
+func (migrationData *MigrationData) FetchAllSeries(from time.Time, until time.Time,
+	fn func(mtf *MTF, points []FieldsPoint) error) error {
+
+	var seriesMTF *MTF
+	var seriesKey string
+	var seriesFiles []string
+	fields := make(map[uint32]map[string]interface{})
+	flush := func() error {
+		if len(fields) == 0 {
+			return nil
+		}
+		points := make([]FieldsPoint, 0, len(fields))
+		for timestamp, pointFields := range fields {
+			points = append(points, FieldsPoint{Timestamp: timestamp, Fields: pointFields})
+		}
+		sort.Slice(points, func(i, j int) bool {
+			return points[i].Timestamp < points[j].Timestamp
+		})
+		fields = make(map[uint32]map[string]interface{})
+		if err := fn(seriesMTF, points); err != nil {
+			return err
+		}
+		for _, wspFile := range seriesFiles {
+			migrationData.report.Written(wspFile)
+		}
+		return nil
+	}
+
+	err := migrationData.FetchAllPoints(from, until,
+		func(wspFile string, wspPoints []whisper.Point) error {
+			if len(wspPoints) == 0 {
+				return nil
+			}
+			mtf, wspPoints := migrationData.MapPoints(wspFile, wspPoints)
+			if key := CreateSeriesKey(mtf); key != seriesKey {
+				if err := flush(); err != nil {
+					return err
+				}
+				seriesKey, seriesMTF, seriesFiles = key, mtf, nil
+			}
+			seriesFiles = append(seriesFiles, wspFile)
+			for _, wspPoint := range wspPoints {
+				if fields[wspPoint.Timestamp] == nil {
+					fields[wspPoint.Timestamp] = make(map[string]interface{})
+				}
+				fields[wspPoint.Timestamp][mtf.Field], _ = InfluxDBValue(mtf.Type,
+					wspPoint.Value)
+			}
+			return nil
+		})
+	if err != nil {
+		//the fields read before a signal are still written
+		if migrationData.Canceled() != nil {
+			if flushErr := flush(); flushErr != nil {
+				return flushErr
+			}
+		}
+		return err
+	}
+	return flush()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-kit/log/level"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type OpenTSDBPoint struct {
+	Metric    string            `json:"metric"`
+	Timestamp int64             `json:"timestamp"`
+	Value     float64           `json:"value"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// Replays the migrated points to the OpenTSDB /api/put endpoint. The metric
+// is <measurement>.<field> and the tags are sent as OpenTSDB tags
+type OpenTSDBSink struct {
+	ctx        context.Context
+	url        string
+	username   string
+	password   string
+	batchSize  int
+	maxRetries int
+	limiter    *RateLimiter
+	client     *http.Client
+	points     []OpenTSDBPoint
+}
+
+func NewOpenTSDBSink(ctx context.Context, url string, username string,
+	password string, batchSize int, maxRetries int, limiter *RateLimiter) *OpenTSDBSink {
+	return &OpenTSDBSink{
+		ctx:        ctx,
+		url:        strings.TrimSuffix(url, "/") + "/api/put?details",
+		username:   username,
+		password:   password,
+		batchSize:  batchSize,
+		maxRetries: maxRetries,
+		limiter:    limiter,
+		client:     &http.Client{Timeout: time.Minute},
+	}
+}
+
+// Replace every character OpenTSDB does not allow in metric names and tags
+// with _. Letters, digits, - _ . and / are allowed
+func SanitizeOpenTSDBName(name string) string {
+	return strings.Map(func(c rune) rune {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) || strings.ContainsRune("-_./", c) {
+			return c
+		}
+		return '_'
+	}, name)
+}
+
+func (sink *OpenTSDBSink) WriteSeries(mtf *MTF, wspPoints []whisper.Point) error {
+	metric := SanitizeOpenTSDBName(mtf.Measurement + "." + mtf.Field)
+	tags := make(map[string]string)
+	for _, tag := range mtf.Tags {
+		if tag.Tagvalue != "" {
+			tags[SanitizeOpenTSDBName(tag.Tagkey)] = SanitizeOpenTSDBName(tag.Tagvalue)
+		}
+	}
+	//OpenTSDB rejects the whole batch for a point without tags
+	if len(tags) == 0 {
+		level.Warn(logger).Log("msg", "Skipping series without tags, OpenTSDB requires a tag",
+			"phase", "write", "series", metric, "points", len(wspPoints))
+		pointsDropped.WithLabelValues("no_tags").Add(float64(len(wspPoints)))
+		return nil
+	}
+	for _, wspPoint := range wspPoints {
+		//OpenTSDB and JSON have no NaN or infinity
+		if math.IsNaN(wspPoint.Value) || math.IsInf(wspPoint.Value, 0) {
+			continue
+		}
+		sink.points = append(sink.points, OpenTSDBPoint{Metric: metric,
+			Timestamp: int64(wspPoint.Timestamp), Value: wspPoint.Value, Tags: tags})
+		if len(sink.points) >= sink.batchSize {
+			if err := sink.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (sink *OpenTSDBSink) flush() error {
+	if len(sink.points) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(sink.points)
+	if err != nil {
+		return err
+	}
+	sink.limiter.Wait(len(sink.points))
+	err = DoWithRetry(sink.ctx, sink.maxRetries, func() (bool, error) {
+		return sink.send(body)
+	})
+	if err != nil {
+		return fmt.Errorf("Error in OpenTSDB put : %s", err)
+	}
+	sink.points = sink.points[:0]
+	return nil
+}
+
+// Send one batch and wait for the response, so a slow OpenTSDB slows down the
+// migration. A 400 response lists the points OpenTSDB rejected and is not
+// retried
+func (sink *OpenTSDBSink) send(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", sink.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if sink.username != "NULL" {
+		req.SetBasicAuth(sink.username, sink.password)
+	}
+	return SendHTTP(sink.client, req)
+}
+
+func (sink *OpenTSDBSink) Close() error {
+	return sink.flush()
+}
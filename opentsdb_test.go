@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/uttamgandhi24/whisper-go/whisper"
+)
+
+func TestSanitizeOpenTSDBName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"cpu.value", "cpu.value"},
+		{"disk used", "disk_used"},
+		{"a/b-c_d", "a/b-c_d"},
+		{"host=a,b", "host_a_b"},
+		{"température", "température"},
+	}
+	for _, test := range tests {
+		if got := SanitizeOpenTSDBName(test.name); got != test.want {
+			t.Errorf("SanitizeOpenTSDBName(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestOpenTSDBSink(t *testing.T) {
+	var batches [][]OpenTSDBPoint
+	var user, password string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/put" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		user, password, _ = r.BasicAuth()
+		var points []OpenTSDBPoint
+		if err := json.NewDecoder(r.Body).Decode(&points); err != nil {
+			t.Error(err)
+		}
+		batches = append(batches, points)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := NewOpenTSDBSink(context.Background(), server.URL+"/", "admin", "secret", 2, 0, nil)
+	mtf := &MTF{Measurement: "cpu", Field: "value",
+		Tags: []TagKeyValue{{Tagkey: "host", Tagvalue: "web 1"}, {Tagkey: "dc", Tagvalue: ""}}}
+	points := []whisper.Point{{Timestamp: 60, Value: 1}, {Timestamp: 120, Value: 2},
+		{Timestamp: 180, Value: 3}}
+	if err := sink.WriteSeries(mtf, points); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 {
+		t.Fatalf("batches %v", batches)
+	}
+	point := batches[1][0]
+	if point.Metric != "cpu.value" || point.Timestamp != 180 || point.Value != 3 ||
+		len(point.Tags) != 1 || point.Tags["host"] != "web_1" {
+		t.Errorf("point %+v", point)
+	}
+	if user != "admin" || password != "secret" {
+		t.Errorf("basic auth %q %q", user, password)
+	}
+}
+
+func TestOpenTSDBSinkSkipsSeriesWithoutTags(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+	}))
+	defer server.Close()
+
+	sink := NewOpenTSDBSink(context.Background(), server.URL, "NULL", "", 10, 0, nil)
+	mtf := &MTF{Measurement: "cpu", Field: "value",
+		Tags: []TagKeyValue{{Tagkey: "host", Tagvalue: ""}}}
+	if err := sink.WriteSeries(mtf, []whisper.Point{{Timestamp: 60, Value: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenTSDBSinkErrors(t *testing.T) {
+	tests := []struct {
+		status   int
+		requests int
+	}{
+		{http.StatusBadRequest, 1},
+		{http.StatusServiceUnavailable, 2},
+	}
+	for _, test := range tests {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.WriteHeader(test.status)
+			w.Write([]byte(`{"failed":1}`))
+		}))
+		sink := NewOpenTSDBSink(context.Background(), server.URL, "NULL", "", 10, 1, nil)
+		sink.WriteSeries(&MTF{Measurement: "cpu", Field: "value",
+			Tags: []TagKeyValue{{Tagkey: "host", Tagvalue: "a"}}},
+			[]whisper.Point{{Timestamp: 60, Value: 1}})
+		err := sink.Close()
+		server.Close()
+		if err == nil || !strings.Contains(err.Error(), `{"failed":1}`) {
+			t.Errorf("status %d: err %v", test.status, err)
+		}
+		if requests != test.requests {
+			t.Errorf("status %d: %d requests, want %d", test.status, requests, test.requests)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-kit/log/level"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Progress of a migration. The readers count the whisper files and bytes
+// read, the writers the points written. On a terminal the progress is
+// redrawn in place every second instead of a line per whisper file,
+// otherwise a progress line is printed every interval. A nil Progress
+// counts nothing and logs every whisper file read at the info level
+type Progress struct {
+	mu        sync.Mutex
+	out       io.Writer
+	tty       bool
+	interval  time.Duration
+	files     int
+	bytes     int64
+	passes    int
+	filesDone int
+	bytesRead int64
+	points    int64
+	start     time.Time
+	stop      chan struct{}
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+// A Progress for files of bytes in total, shown on stderr. An interval of 0
+// turns the progress off and returns nil
+func NewProgress(interval time.Duration, files int, bytes int64) *Progress {
+	if interval <= 0 {
+		return nil
+	}
+	tty := false
+	if fileinfo, err := os.Stderr.Stat(); err == nil {
+		tty = fileinfo.Mode()&os.ModeCharDevice != 0
+	}
+	return &Progress{out: os.Stderr, tty: tty, interval: interval,
+		files: files, bytes: bytes, passes: 1}
+}
+
+// The whisper files are read passes times, e.g. once per shard
+func (progress *Progress) SetPasses(passes int) {
+	if progress == nil || passes < 1 {
+		return
+	}
+	progress.mu.Lock()
+	progress.passes = passes
+	progress.mu.Unlock()
+}
+
+// Show the progress until Stop
+func (progress *Progress) Start() {
+	if progress == nil {
+		return
+	}
+	progress.start = time.Now()
+	progress.stop = make(chan struct{})
+	progress.done = make(chan struct{})
+	interval := progress.interval
+	if progress.tty {
+		interval = time.Second
+	}
+	go func() {
+		defer close(progress.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				progress.print()
+			case <-progress.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop showing the progress and print it a last time. Stop can be called
+// more than once
+func (progress *Progress) Stop() {
+	if progress == nil || progress.stop == nil {
+		return
+	}
+	progress.stopOnce.Do(func() {
+		close(progress.stop)
+		<-progress.done
+		progress.print()
+		if progress.tty {
+			fmt.Fprintln(progress.out)
+		}
+	})
+}
+
+// A whisper file is being read, logged at the debug level when the progress
+// is shown and at the info level otherwise
+func (progress *Progress) Reading(wspFile string, keyvals ...interface{}) {
+	l := level.Info(logger)
+	if progress != nil {
+		l = level.Debug(logger)
+	}
+	l.Log(append([]interface{}{"msg", "Migrating Data From", "phase", "read",
+		"file", wspFile}, keyvals...)...)
+}
+
+// A whisper file of size bytes has been read
+func (progress *Progress) FileDone(size int64) {
+	if progress == nil {
+		return
+	}
+	progress.mu.Lock()
+	progress.filesDone = progress.filesDone + 1
+	progress.bytesRead = progress.bytesRead + size
+	progress.mu.Unlock()
+}
+
+// n points have been written
+func (progress *Progress) PointsWritten(n int) {
+	if progress == nil {
+		return
+	}
+	progress.mu.Lock()
+	progress.points = progress.points + int64(n)
+	progress.mu.Unlock()
+}
+
+func (progress *Progress) print() {
+	line := progress.String()
+	if progress.tty {
+		fmt.Fprintf(progress.out, "\r\033[K%s", line)
+		return
+	}
+	fmt.Fprintln(progress.out, line)
+}
+
+func (progress *Progress) String() string {
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	elapsed := time.Since(progress.start)
+	seconds := elapsed.Seconds()
+	if seconds <= 0 {
+		seconds = 1
+	}
+	totalFiles := progress.files * progress.passes
+	size, unit := formatSize(progress.bytesRead)
+	rate, rateUnit := formatSize(int64(float64(progress.bytesRead) / seconds))
+	line := fmt.Sprintf("Progress %d/%d files, %d points, %.2f %s read, %.0f points/s, %.2f %s/s",
+		progress.filesDone, totalFiles, progress.points, size, unit,
+		float64(progress.points)/seconds, rate, rateUnit)
+
+	//whisper files differ in size, the bytes read tell the work done better
+	//than the files read
+	var done float64
+	if totalBytes := progress.bytes * int64(progress.passes); totalBytes > 0 {
+		done = float64(progress.bytesRead) / float64(totalBytes)
+	} else if totalFiles > 0 {
+		done = float64(progress.filesDone) / float64(totalFiles)
+	}
+	if done > 0 && done < 1 {
+		eta := time.Duration(float64(elapsed) * (1 - done) / done)
+		line = line + fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+	}
+	return line
+}
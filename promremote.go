@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Samples sent in one remote write request
+const promBatchSamples = 10000
+
+// Sends the migrated points as Prometheus samples to a remote write endpoint.
+// The metric name is <measurement>_<field> and the tags become labels
+type PromRemoteWriteSink struct {
+	ctx        context.Context
+	url        string
+	username   string
+	password   string
+	maxRetries int
+	client     *http.Client
+	request    prompb.WriteRequest
+	samples    int
+}
+
+func NewPromRemoteWriteSink(ctx context.Context, url string, username string,
+	password string, maxRetries int) *PromRemoteWriteSink {
+	return &PromRemoteWriteSink{
+		ctx:        ctx,
+		url:        url,
+		username:   username,
+		password:   password,
+		maxRetries: maxRetries,
+		client:     &http.Client{Timeout: time.Minute},
+	}
+}
+
+// Replace every character not allowed in a Prometheus metric or label name
+// with _ and prefix names starting with a digit with _
+func SanitizePromName(name string) string {
+	var b strings.Builder
+	for i, c := range name {
+		switch {
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			b.WriteRune(c)
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(c)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// Labels of a series sorted by name, as remote write requires. Label names
+// starting with __ are reserved for Prometheus, tags mapping to one are
+// prefixed with tag, and of tags mapping to the same name the first wins
+func PromLabels(mtf *MTF) []prompb.Label {
+	labels := []prompb.Label{{Name: "__name__",
+		Value: SanitizePromName(mtf.Measurement + "_" + mtf.Field)}}
+	seen := map[string]bool{"__name__": true}
+	for _, tag := range mtf.Tags {
+		name := SanitizePromName(tag.Tagkey)
+		if strings.HasPrefix(name, "__") {
+			name = "tag" + name
+		}
+		if seen[name] || tag.Tagvalue == "" {
+			continue
+		}
+		seen[name] = true
+		labels = append(labels, prompb.Label{Name: name, Value: tag.Tagvalue})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+	return labels
+}
+
+func (sink *PromRemoteWriteSink) WriteSeries(mtf *MTF, wspPoints []whisper.Point) error {
+	labels := PromLabels(mtf)
+	for len(wspPoints) > 0 {
+		n := promBatchSamples - sink.samples
+		if n > len(wspPoints) {
+			n = len(wspPoints)
+		}
+		series := prompb.TimeSeries{Labels: labels, Samples: make([]prompb.Sample, n)}
+		for i, wspPoint := range wspPoints[:n] {
+			series.Samples[i] = prompb.Sample{Value: wspPoint.Value,
+				Timestamp: int64(wspPoint.Timestamp) * 1000}
+		}
+		sink.request.Timeseries = append(sink.request.Timeseries, series)
+		sink.samples = sink.samples + n
+		wspPoints = wspPoints[n:]
+		if sink.samples >= promBatchSamples {
+			if err := sink.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (sink *PromRemoteWriteSink) flush() error {
+	if sink.samples == 0 {
+		return nil
+	}
+	data, err := sink.request.Marshal()
+	if err != nil {
+		return err
+	}
+	body := snappy.Encode(nil, data)
+	err = DoWithRetry(sink.ctx, sink.maxRetries, func() (bool, error) {
+		return sink.send(body)
+	})
+	if err != nil {
+		return fmt.Errorf("Error in remote write : %s", err)
+	}
+	sink.request.Timeseries = sink.request.Timeseries[:0]
+	sink.samples = 0
+	return nil
+}
+
+// Send one snappy compressed request
+func (sink *PromRemoteWriteSink) send(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", sink.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if sink.username != "NULL" {
+		req.SetBasicAuth(sink.username, sink.password)
+	}
+	return SendHTTP(sink.client, req)
+}
+
+func (sink *PromRemoteWriteSink) Close() error {
+	return sink.flush()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+)
+
+func TestSanitizePromName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"cpu_value", "cpu_value"},
+		{"cpu.value", "cpu_value"},
+		{"5xx", "_5xx"},
+		{"a5", "a5"},
+		{"disk used%", "disk_used_"},
+		{"", "_"},
+	}
+	for _, test := range tests {
+		if got := SanitizePromName(test.name); got != test.want {
+			t.Errorf("SanitizePromName(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPromLabels(t *testing.T) {
+	mtf := &MTF{Measurement: "cpu", Field: "value", Tags: []TagKeyValue{
+		{Tagkey: "host", Tagvalue: "a"}, {Tagkey: "__name__", Tagvalue: "x"},
+		{Tagkey: "dc", Tagvalue: ""}, {Tagkey: "host.name", Tagvalue: "b"},
+		{Tagkey: "host_name", Tagvalue: "c"}}}
+	var got []string
+	for _, label := range PromLabels(mtf) {
+		got = append(got, label.Name+"="+label.Value)
+	}
+	want := "__name__=cpu_value,host=a,host_name=b,tag__name__=x"
+	if strings.Join(got, ",") != want {
+		t.Errorf("PromLabels = %s, want %s", strings.Join(got, ","), want)
+	}
+}
+
+func TestPromRemoteWriteSink(t *testing.T) {
+	var requests []prompb.WriteRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" ||
+			r.Header.Get("Content-Type") != "application/x-protobuf" ||
+			r.Header.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" {
+			t.Errorf("headers %v", r.Header)
+		}
+		if user, password, _ := r.BasicAuth(); user != "prom" || password != "secret" {
+			t.Errorf("basic auth %q %q", user, password)
+		}
+		compressed, _ := ioutil.ReadAll(r.Body)
+		data, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Error(err)
+		}
+		var request prompb.WriteRequest
+		if err := request.Unmarshal(data); err != nil {
+			t.Error(err)
+		}
+		requests = append(requests, request)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := NewPromRemoteWriteSink(context.Background(), server.URL, "prom", "secret", 0)
+	mtf := &MTF{Measurement: "cpu", Field: "value",
+		Tags: []TagKeyValue{{Tagkey: "host", Tagvalue: "a"}}}
+	points := make([]whisper.Point, promBatchSamples+5)
+	for i := range points {
+		points[i] = whisper.Point{Timestamp: uint32(60 * (i + 1)), Value: float64(i)}
+	}
+	if err := sink.WriteSeries(mtf, points); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("%d requests, want 2", len(requests))
+	}
+	last := requests[1].Timeseries[0]
+	if len(last.Samples) != 5 || last.Samples[4].Timestamp != int64(60*len(points))*1000 ||
+		last.Labels[0].Value != "cpu_value" || last.Labels[1].Name != "host" {
+		t.Errorf("last series %v", last)
+	}
+}
+
+func TestPromRemoteWriteSinkError(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	sink := NewPromRemoteWriteSink(context.Background(), server.URL, "NULL", "", 3)
+	sink.WriteSeries(&MTF{Measurement: "cpu", Field: "value"},
+		[]whisper.Point{{Timestamp: 60, Value: 1}})
+	err := sink.Close()
+	if err == nil || !strings.Contains(err.Error(), "out of order sample") {
+		t.Fatalf("err %v", err)
+	}
+	//a 400 is not retried
+	if requests != 1 {
+		t.Errorf("%d requests", requests)
+	}
+}
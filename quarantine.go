@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-kit/log/level"
+	"io"
+	"os"
+)
+
+type QuarantinedFile struct {
+	wspFile string
+	reason  string
+}
+
+// Validate every whisper file found and quarantine the ones which are corrupt
+// or truncated, so that they are never handed to the whisper reader. The
+// resolution of the valid ones is kept for the $resolution tag
+func (migrationData *MigrationData) ValidateWhisperFiles() {
+	migrationData.resolutions = make(map[string]uint32)
+	defer migrationData.DropQuarantined()
+	if migrationData.source == "ceres" {
+		migrationData.ValidateCeresNodes()
+		return
+	}
+	err := migrationData.EachWhisperFile(func(wspFile string, r io.ReaderAt, size int64) error {
+		header, err := ValidateWhisperData(r, size)
+		if err != nil {
+			migrationData.Quarantine(wspFile, err)
+			return nil
+		}
+		migrationData.resolutions[wspFile] = header.Archives[0].SecondsPerPoint
+		return nil
+	})
+	if err != nil {
+		level.Error(logger).Log("msg", "Error in validating whisper files",
+			"phase", "validate", "err", err)
+	}
+}
+
+// Record a whisper file which can not be migrated and append it with the
+// reason to the quarantine list. The file stays in wspFiles, the loops over
+// them skip the quarantined ones until DropQuarantined removes them
+func (migrationData *MigrationData) Quarantine(wspFile string, reason error) {
+	if migrationData.IsQuarantined(wspFile) {
+		return
+	}
+	level.Warn(logger).Log("msg", "Quarantining", "file", wspFile, "err", reason)
+	migrationErrors.WithLabelValues("quarantine").Inc()
+	migrationData.report.FileSkipped(wspFile, "quarantined: "+reason.Error())
+	migrationData.report.Error(fmt.Errorf("%s : %s", wspFile, reason))
+	migrationData.quarantined = append(migrationData.quarantined,
+		QuarantinedFile{wspFile: wspFile, reason: reason.Error()})
+	if migrationData.quarantinedSet == nil {
+		migrationData.quarantinedSet = make(map[string]bool)
+	}
+	migrationData.quarantinedSet[wspFile] = true
+	migrationData.whisperFileSize = migrationData.whisperFileSize -
+		migrationData.wspFileSizes[wspFile]
+
+	if migrationData.quarantineList == "NULL" {
+		return
+	}
+	f, err := os.OpenFile(migrationData.quarantineList,
+		os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		level.Error(logger).Log("msg", "Could not open quarantine list", "err", err)
+		return
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, "%s\t%s\n", wspFile, reason); err != nil {
+		level.Error(logger).Log("msg", "Could not write quarantine list", "err", err)
+	}
+}
+
+func (migrationData *MigrationData) IsQuarantined(wspFile string) bool {
+	return migrationData.quarantinedSet[wspFile]
+}
+
+// Remove the quarantined files from wspFiles, once the loops over them are
+// done
+func (migrationData *MigrationData) DropQuarantined() {
+	if len(migrationData.quarantinedSet) == 0 {
+		return
+	}
+	wspFiles := make([]string, 0, len(migrationData.wspFiles))
+	for _, wspFile := range migrationData.wspFiles {
+		if !migrationData.quarantinedSet[wspFile] {
+			wspFiles = append(wspFiles, wspFile)
+		}
+	}
+	migrationData.wspFiles = wspFiles
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestQuarantine(t *testing.T) {
+	quarantineList := filepath.Join(t.TempDir(), "quarantine.txt")
+	migrationData := &MigrationData{quarantineList: quarantineList}
+	migrationData.AddWhisperFile("a.wsp", 10)
+	migrationData.AddWhisperFile("b.wsp", 20)
+	migrationData.AddWhisperFile("c.wsp", 30)
+
+	for _, wspFile := range migrationData.wspFiles {
+		if wspFile == "b.wsp" {
+			migrationData.Quarantine(wspFile, fmt.Errorf("truncated"))
+			//quarantined once only
+			migrationData.Quarantine(wspFile, fmt.Errorf("truncated"))
+		}
+	}
+	if !migrationData.IsQuarantined("b.wsp") || migrationData.IsQuarantined("a.wsp") {
+		t.Errorf("quarantined %v", migrationData.quarantinedSet)
+	}
+	if migrationData.whisperFileSize != 40 {
+		t.Errorf("whisperFileSize %d, want 40", migrationData.whisperFileSize)
+	}
+	//the files are kept for the loops still ranging over them
+	if len(migrationData.wspFiles) != 3 {
+		t.Errorf("wspFiles %v before DropQuarantined", migrationData.wspFiles)
+	}
+	migrationData.DropQuarantined()
+	if len(migrationData.wspFiles) != 2 || migrationData.wspFiles[1] != "c.wsp" {
+		t.Errorf("wspFiles %v after DropQuarantined", migrationData.wspFiles)
+	}
+	raw, err := ioutil.ReadFile(quarantineList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != "b.wsp\ttruncated\n" || len(migrationData.quarantined) != 1 {
+		t.Errorf("quarantine list %q", raw)
+	}
+}
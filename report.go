@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+)
+
+// The report written with -report, to keep as evidence of a migration run.
+// A nil Report records nothing
+type Report struct {
+	Started       time.Time          `json:"started"`
+	Finished      time.Time          `json:"finished"`
+	Parameters    ReportParameters   `json:"parameters"`
+	Files         []*ReportFile      `json:"files"`
+	Shards        []ReportShard      `json:"shards,omitempty"`
+	Errors        []string           `json:"errors"`
+	Phases        map[string]float64 `json:"phase_seconds"`
+	WhisperSize   int64              `json:"whisper_size"`
+	TSMSize       int64              `json:"tsm_size,omitempty"`
+	SizeReduction float64            `json:"size_reduction,omitempty"`
+	PointsRead    int                `json:"points_read"`
+	PointsWritten int                `json:"points_written"`
+	Rejected      int                `json:"points_not_matching_type"`
+	Deduped       int                `json:"points_already_in_influxdb"`
+	files         map[string]*ReportFile
+}
+
+type ReportParameters struct {
+	Option          string    `json:"option"`
+	Source          string    `json:"source"`
+	WspPath         string    `json:"wsp_path"`
+	From            time.Time `json:"from"`
+	Until           time.Time `json:"until"`
+	TimeShift       string    `json:"time_shift,omitempty"`
+	DBName          string    `json:"dbname"`
+	RetentionPolicy string    `json:"retention_policy"`
+	Dedup           string    `json:"dedup"`
+	Resample        string    `json:"resample,omitempty"`
+}
+
+// Outcome of a whisper file, summed over all passes
+type ReportFile struct {
+	File          string `json:"file"`
+	Series        string `json:"series,omitempty"`
+	PointsRead    int    `json:"points_read"`
+	PointsWritten int    `json:"points_written"`
+	Skipped       string `json:"skipped,omitempty"`
+	Partial       bool   `json:"partial,omitempty"`
+	pending       int
+}
+
+type ReportShard struct {
+	ID    string    `json:"id"`
+	From  time.Time `json:"from"`
+	Until time.Time `json:"until"`
+	File  string    `json:"file"`
+	Size  int64     `json:"size"`
+}
+
+func NewReport(migrationData *MigrationData) *Report {
+	report := &Report{Started: time.Now(), Errors: []string{},
+		Phases: make(map[string]float64), files: make(map[string]*ReportFile)}
+	report.Parameters = ReportParameters{
+		Option:          migrationData.option,
+		Source:          migrationData.source,
+		WspPath:         migrationData.wspPath,
+		From:            migrationData.from,
+		Until:           migrationData.until,
+		DBName:          migrationData.dbName,
+		RetentionPolicy: migrationData.retentionPolicy,
+		Dedup:           migrationData.dedup,
+	}
+	if migrationData.timeShift != 0 {
+		report.Parameters.TimeShift = migrationData.timeShift.String()
+	}
+	if migrationData.resample != nil {
+		report.Parameters.Resample = migrationData.resample.String()
+	}
+	return report
+}
+
+func (report *Report) file(wspFile string) *ReportFile {
+	file := report.files[wspFile]
+	if file == nil {
+		file = &ReportFile{File: wspFile}
+		report.files[wspFile] = file
+		report.Files = append(report.Files, file)
+	}
+	return file
+}
+
+// List the whisper files to migrate, also the ones without points
+func (report *Report) AddFiles(wspFiles []string) {
+	if report == nil {
+		return
+	}
+	for _, wspFile := range wspFiles {
+		report.file(wspFile)
+	}
+}
+
+// read points of a whisper file are mapped to mapped points of series, which
+// count as written once Written or AllWritten is called
+func (report *Report) FileMapped(wspFile string, series string, read int,
+	mapped int) {
+
+	if report == nil {
+		return
+	}
+	file := report.file(wspFile)
+	file.Series = series
+	file.PointsRead = file.PointsRead + read
+	file.pending = file.pending + mapped
+	report.PointsRead = report.PointsRead + read
+}
+
+// The points mapped of a whisper file have been written
+func (report *Report) Written(wspFile string) {
+	if report == nil {
+		return
+	}
+	file := report.file(wspFile)
+	file.PointsWritten = file.PointsWritten + file.pending
+	report.PointsWritten = report.PointsWritten + file.pending
+	file.pending = 0
+}
+
+// The points mapped of all whisper files have been written, e.g. once the
+// TSM file of a shard is complete
+func (report *Report) AllWritten() {
+	if report == nil {
+		return
+	}
+	for _, file := range report.Files {
+		report.Written(file.File)
+	}
+}
+
+// The points mapped since the last write are not written, e.g. a failed
+// sync pass which is read again
+func (report *Report) NotWritten() {
+	if report == nil {
+		return
+	}
+	for _, file := range report.Files {
+		file.pending = 0
+	}
+}
+
+// A whisper file is not migrated for reason. A file skipped after some of its
+// points were written, e.g. quarantined in a later TSMW shard, is partially
+// migrated
+func (report *Report) FileSkipped(wspFile string, reason string) {
+	if report == nil {
+		return
+	}
+	file := report.file(wspFile)
+	file.Skipped = reason
+	file.Partial = file.PointsWritten > 0
+}
+
+func (report *Report) Shard(shard ShardInfo, filename string, size int64) {
+	if report == nil {
+		return
+	}
+	report.Shards = append(report.Shards, ReportShard{ID: shard.id.String(),
+		From: shard.from, Until: shard.until, File: filename, Size: size})
+}
+
+func (report *Report) Error(err error) {
+	if report == nil {
+		return
+	}
+	report.Errors = append(report.Errors, err.Error())
+}
+
+// Add the time since start to a phase, e.g. scan, validate or migrate
+func (report *Report) Phase(phase string, start time.Time) {
+	if report == nil {
+		return
+	}
+	report.Phases[phase] = report.Phases[phase] + time.Since(start).Seconds()
+}
+
+// Write the report with the totals of migrationData as JSON to filename
+func (report *Report) Write(filename string, migrationData *MigrationData) error {
+	if report == nil {
+		return nil
+	}
+	report.Finished = time.Now()
+	report.WhisperSize = migrationData.whisperFileSize
+	report.Rejected = migrationData.rejectedPoints
+	report.Deduped = migrationData.dedupedPoints
+	if migrationData.option == "TSMW" && migrationData.whisperFileSize > 0 {
+		report.TSMSize = migrationData.tsmFileSize
+		report.SizeReduction = float64(migrationData.whisperFileSize-
+			migrationData.tsmFileSize) / float64(migrationData.whisperFileSize)
+	}
+	raw, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, raw, 0666)
+}
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestReportCountsWrittenPointsAfterTheWrite(t *testing.T) {
+	report := NewReport(&MigrationData{})
+	report.AddFiles([]string{"a.wsp", "b.wsp"})
+	report.FileMapped("a.wsp", "cpu#!~#value", 10, 8)
+	report.FileMapped("b.wsp", "mem#!~#value", 5, 5)
+	if report.PointsRead != 15 || report.PointsWritten != 0 {
+		t.Fatalf("read %d written %d before the write", report.PointsRead, report.PointsWritten)
+	}
+
+	report.Written("a.wsp")
+	if report.PointsWritten != 8 || report.files["a.wsp"].PointsWritten != 8 ||
+		report.files["b.wsp"].PointsWritten != 0 {
+		t.Fatalf("written %d after writing a.wsp", report.PointsWritten)
+	}
+
+	report.NotWritten()
+	report.FileMapped("b.wsp", "mem#!~#value", 5, 4)
+	report.AllWritten()
+	if report.PointsWritten != 12 || report.files["b.wsp"].PointsWritten != 4 {
+		t.Fatalf("written %d, b.wsp %d", report.PointsWritten, report.files["b.wsp"].PointsWritten)
+	}
+	report.AllWritten()
+	if report.PointsWritten != 12 {
+		t.Fatalf("written %d after a second AllWritten", report.PointsWritten)
+	}
+}
+
+func TestReportPartialFile(t *testing.T) {
+	report := NewReport(&MigrationData{})
+	report.AddFiles([]string{"a.wsp", "b.wsp"})
+	//a.wsp was written in the first shard and fails in the second
+	report.FileMapped("a.wsp", "cpu#!~#value", 10, 10)
+	report.AllWritten()
+	report.FileSkipped("a.wsp", "quarantined: truncated")
+	report.FileSkipped("b.wsp", "quarantined: truncated")
+	if !report.files["a.wsp"].Partial || report.files["b.wsp"].Partial {
+		t.Errorf("partial a.wsp %v, b.wsp %v", report.files["a.wsp"].Partial,
+			report.files["b.wsp"].Partial)
+	}
+}
+
+func TestNilReport(t *testing.T) {
+	var report *Report
+	report.FileMapped("a.wsp", "", 1, 1)
+	report.Written("a.wsp")
+	report.AllWritten()
+	report.NotWritten()
+	if err := report.Write("unused.json", &MigrationData{}); err != nil {
+		t.Fatal(err)
+	}
+}
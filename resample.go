@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+	"math"
+	"time"
+)
+
+// Resampling of a series to a coarser interval, e.g.
+// {"interval": "5m", "aggregate": "mean", "xff": 0.5}. A bucket of interval
+// is written when at least xff of the points it should hold are known
+type Resample struct {
+	Interval     string  `json:"interval"`
+	Aggregate    string  `json:"aggregate,omitempty"`
+	XFilesFactor float64 `json:"xff,omitempty"`
+	seconds      uint32
+}
+
+// Parse the interval and check the aggregate, mean is the default
+func (resample *Resample) Init() error {
+	interval, err := ParseDuration(resample.Interval)
+	if err != nil || interval < time.Second || interval%time.Second != 0 {
+		return fmt.Errorf("invalid resample interval %q", resample.Interval)
+	}
+	resample.seconds = uint32(interval / time.Second)
+	if resample.Aggregate == "" {
+		resample.Aggregate = "mean"
+	}
+	switch resample.Aggregate {
+	case "mean", "sum", "min", "max", "last", "count":
+	default:
+		return fmt.Errorf("unknown resample aggregate %q", resample.Aggregate)
+	}
+	if resample.XFilesFactor < 0 || resample.XFilesFactor > 1 {
+		return fmt.Errorf("resample xff %v is not between 0 and 1", resample.XFilesFactor)
+	}
+	return nil
+}
+
+func (resample *Resample) String() string {
+	return fmt.Sprintf("%s(%ds, xff %v)", resample.Aggregate, resample.seconds,
+		resample.XFilesFactor)
+}
+
+// Interval of the points read from a whisper archive, the smallest distance
+// of two points, 0 for a single point
+func PointStep(wspPoints []whisper.Point) uint32 {
+	var step uint32
+	for i := 1; i < len(wspPoints); i++ {
+		if d := wspPoints[i].Timestamp - wspPoints[i-1].Timestamp; d > 0 && (step == 0 || d < step) {
+			step = d
+		}
+	}
+	return step
+}
+
+// Start of the bucket holding timestamp
+func (resample *Resample) Bucket(timestamp uint32) uint32 {
+	return timestamp - timestamp%resample.seconds
+}
+
+// Time range to read for the window from, until, like a whisper fetch from is
+// excluded. A bucket belongs to the window it starts in and is read whole, the
+// points after until included, so that a bucket is never written twice with
+// the partial values of two windows, e.g. TSMW shards or sync passes
+func (resample *Resample) FetchRange(from time.Time, until time.Time) (time.Time, time.Time) {
+	seconds := int64(resample.seconds)
+	first := (from.Unix() + seconds - 1) / seconds * seconds
+	last := (until.Unix() - 1) / seconds * seconds
+	if first > last {
+		//no bucket starts in the window, WindowPoints drops every point
+		return from, until
+	}
+	return time.Unix(first-1, 0), time.Unix(last+seconds-1, 0)
+}
+
+// Points of the buckets starting in the window from, until
+func (resample *Resample) WindowPoints(wspPoints []whisper.Point, from time.Time,
+	until time.Time) []whisper.Point {
+
+	var points []whisper.Point
+	for _, wspPoint := range wspPoints {
+		bucket := int64(resample.Bucket(wspPoint.Timestamp))
+		if bucket >= from.Unix() && bucket < until.Unix() {
+			points = append(points, wspPoint)
+		}
+	}
+	return points
+}
+
+// Aggregate the points, in time order, into buckets of the resample interval
+// aligned to the epoch. step is the interval of the source points, the number
+// of points a bucket should hold is interval/step
+func (resample *Resample) Apply(wspPoints []whisper.Point, step uint32) []whisper.Point {
+	expected := 1.0
+	if step > 0 && step < resample.seconds {
+		expected = float64(resample.seconds) / float64(step)
+	}
+	var points []whisper.Point
+	for i := 0; i < len(wspPoints); {
+		bucket := resample.Bucket(wspPoints[i].Timestamp)
+		var known []float64
+		for ; i < len(wspPoints) && resample.Bucket(wspPoints[i].Timestamp) == bucket; i++ {
+			if !math.IsNaN(wspPoints[i].Value) {
+				known = append(known, wspPoints[i].Value)
+			}
+		}
+		if len(known) == 0 || float64(len(known))/expected < resample.XFilesFactor {
+			continue
+		}
+		points = append(points, whisper.Point{Timestamp: bucket,
+			Value: resample.aggregate(known)})
+	}
+	return points
+}
+
+func (resample *Resample) aggregate(values []float64) float64 {
+	value := values[0]
+	switch resample.Aggregate {
+	case "mean", "sum":
+		value = 0
+		for _, v := range values {
+			value = value + v
+		}
+		if resample.Aggregate == "mean" {
+			value = value / float64(len(values))
+		}
+	case "min":
+		for _, v := range values {
+			value = math.Min(value, v)
+		}
+	case "max":
+		for _, v := range values {
+			value = math.Max(value, v)
+		}
+	case "last":
+		value = values[len(values)-1]
+	case "count":
+		value = float64(len(values))
+	}
+	return value
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/uttamgandhi24/whisper-go/whisper"
+)
+
+func TestResampleInit(t *testing.T) {
+	tests := []struct {
+		resample Resample
+		seconds  uint32
+		err      bool
+	}{
+		{Resample{Interval: "5m"}, 300, false},
+		{Resample{Interval: "1d", Aggregate: "sum", XFilesFactor: 1}, 86400, false},
+		{Resample{Interval: "500ms"}, 0, true},
+		{Resample{Interval: "1.5s"}, 0, true},
+		{Resample{Interval: "5m", Aggregate: "median"}, 0, true},
+		{Resample{Interval: "5m", XFilesFactor: 1.5}, 0, true},
+	}
+	for _, test := range tests {
+		resample := test.resample
+		err := resample.Init()
+		if (err != nil) != test.err {
+			t.Errorf("Init(%+v) err %v", test.resample, err)
+			continue
+		}
+		if err == nil && (resample.seconds != test.seconds || resample.Aggregate == "") {
+			t.Errorf("Init(%+v) = %+v", test.resample, resample)
+		}
+	}
+}
+
+func TestResampleApply(t *testing.T) {
+	nan := math.NaN()
+	//minutely points, two 5 minute buckets from 600 on and a lone point at 1260
+	points := []whisper.Point{
+		{Timestamp: 600, Value: 1}, {Timestamp: 660, Value: 2}, {Timestamp: 720, Value: 3},
+		{Timestamp: 780, Value: nan}, {Timestamp: 840, Value: 6},
+		{Timestamp: 900, Value: 10}, {Timestamp: 960, Value: nan},
+		{Timestamp: 1260, Value: 4},
+	}
+	tests := []struct {
+		aggregate string
+		xff       float64
+		buckets   []uint32
+		values    []float64
+	}{
+		{"mean", 0, []uint32{600, 900, 1200}, []float64{3, 10, 4}},
+		{"sum", 0, []uint32{600, 900, 1200}, []float64{12, 10, 4}},
+		{"min", 0, []uint32{600, 900, 1200}, []float64{1, 10, 4}},
+		{"max", 0, []uint32{600, 900, 1200}, []float64{6, 10, 4}},
+		{"last", 0, []uint32{600, 900, 1200}, []float64{6, 10, 4}},
+		{"count", 0, []uint32{600, 900, 1200}, []float64{4, 1, 1}},
+		//4 of 5 points known in the first bucket, 1 of 5 in the others
+		{"mean", 0.5, []uint32{600}, []float64{3}},
+		{"mean", 0.8, []uint32{600}, []float64{3}},
+		{"mean", 0.9, nil, nil},
+	}
+	for _, test := range tests {
+		resample := &Resample{Interval: "5m", Aggregate: test.aggregate, XFilesFactor: test.xff}
+		if err := resample.Init(); err != nil {
+			t.Fatal(err)
+		}
+		got := resample.Apply(points, PointStep(points))
+		if len(got) != len(test.buckets) {
+			t.Errorf("%s xff %v: %v, want buckets %v", test.aggregate, test.xff, got, test.buckets)
+			continue
+		}
+		for i := range got {
+			if got[i].Timestamp != test.buckets[i] || got[i].Value != test.values[i] {
+				t.Errorf("%s xff %v: %v, want %v %v", test.aggregate, test.xff, got,
+					test.buckets, test.values)
+				break
+			}
+		}
+	}
+}
+
+func TestResampleWindows(t *testing.T) {
+	//minutely points 1 to 10 in the 5 minute buckets 600 and 900
+	var points []whisper.Point
+	for i := uint32(0); i < 10; i++ {
+		points = append(points, whisper.Point{Timestamp: 600 + i*60, Value: float64(i + 1)})
+	}
+	resample := &Resample{Interval: "5m", Aggregate: "sum"}
+	if err := resample.Init(); err != nil {
+		t.Fatal(err)
+	}
+	//the bucket 600 is split by the windows, it is read whole by the first
+	var got []whisper.Point
+	for _, window := range [][2]int64{{600, 780}, {780, 1200}} {
+		from, until := resample.FetchRange(time.Unix(window[0], 0), time.Unix(window[1], 0))
+		var fetched []whisper.Point
+		for _, point := range points {
+			if int64(point.Timestamp) > from.Unix() && int64(point.Timestamp) <= until.Unix() {
+				fetched = append(fetched, point)
+			}
+		}
+		fetched = resample.WindowPoints(fetched, time.Unix(window[0], 0),
+			time.Unix(window[1], 0))
+		got = append(got, resample.Apply(fetched, PointStep(fetched))...)
+	}
+	want := []whisper.Point{{Timestamp: 600, Value: 15}, {Timestamp: 900, Value: 40}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("windows %v, want %v", got, want)
+	}
+}
+
+func TestPointStep(t *testing.T) {
+	tests := []struct {
+		timestamps []uint32
+		want       uint32
+	}{
+		{nil, 0},
+		{[]uint32{60}, 0},
+		{[]uint32{60, 120, 300}, 60},
+		{[]uint32{0, 300, 310, 600}, 10},
+	}
+	for _, test := range tests {
+		var points []whisper.Point
+		for _, timestamp := range test.timestamps {
+			points = append(points, whisper.Point{Timestamp: timestamp})
+		}
+		if got := PointStep(points); got != test.want {
+			t.Errorf("PointStep(%v) = %d, want %d", test.timestamps, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/go-kit/log/level"
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// A Sink writes the mapped points of one whisper file at a time to a
+// migration target other than the TSMW and ClientV2 options
+type Sink interface {
+	WriteSeries(mtf *MTF, wspPoints []whisper.Point) error
+	Close() error
+}
+
+// Options accepted by -option
+func IsValidOption(option string) bool {
+	switch option {
+	case "TSMW", "ClientV2", "InfluxDB2", "LP", "CSV", "Parquet",
+		"PromRemoteWrite", "TSDB", "OpenTSDB", "Graphite", "Whisper":
+		return true
+	}
+	return false
+}
+
+// Create the Sink for the -option given
+func (migrationData *MigrationData) NewSink() (Sink, error) {
+	switch migrationData.option {
+	case "ClientV2":
+		return migrationData.NewClientV2Sink()
+	case "InfluxDB2":
+		sink := NewInfluxDB2Sink(migrationData.host+":"+migrationData.port,
+			migrationData.org, migrationData.bucket, migrationData.token)
+		if err := sink.EnsureBucket(migrationData.MaxRetention()); err != nil {
+			return nil, err
+		}
+		return sink, nil
+	case "LP":
+		return NewLPSink(migrationData.lpDir, migrationData.dbName,
+			migrationData.retentionPolicy, migrationData.lpGzip,
+			migrationData.lpSplitSize, migrationData.lpSplitDuration)
+	case "CSV":
+		return NewCSVSink(migrationData.exportDir, migrationData.TagKeys()), nil
+	case "Parquet":
+		return NewParquetSink(migrationData.exportDir, migrationData.TagKeys()), nil
+	case "PromRemoteWrite":
+		return NewPromRemoteWriteSink(migrationData.Context(), migrationData.remoteWriteURL,
+			migrationData.username, migrationData.password,
+			migrationData.maxRetries), nil
+	case "OpenTSDB":
+		return NewOpenTSDBSink(migrationData.Context(), migrationData.openTSDBURL,
+			migrationData.username, migrationData.password, migrationData.batchSize,
+			migrationData.maxRetries, NewRateLimiter(migrationData.rateLimit)), nil
+	case "Graphite":
+		return NewGraphiteSink(migrationData.Context(), migrationData.graphiteAddr,
+			migrationData.batchSize, migrationData.maxRetries,
+			NewRateLimiter(migrationData.rateLimit)), nil
+	}
+	return nil, fmt.Errorf("Unknown option %s", migrationData.option)
+}
+
+// Migrate every whisper file for the whole time range into sink
+func (migrationData *MigrationData) WriteUsingSink(sink Sink) error {
+	err := migrationData.FetchAllPoints(migrationData.from, migrationData.until,
+		func(wspFile string, wspPoints []whisper.Point) error {
+			if len(wspPoints) == 0 {
+				return nil
+			}
+			mtf, wspPoints := migrationData.MapPoints(wspFile, wspPoints)
+			start := time.Now()
+			if err := sink.WriteSeries(mtf, wspPoints); err != nil {
+				migrationErrors.WithLabelValues("write").Inc()
+				return fmt.Errorf("Error in writing %s : %s", CreateTSMKey(mtf), err)
+			}
+			level.Debug(logger).Log("msg", "Series written", "phase", "write",
+				"file", wspFile, "series", CreateTSMKey(mtf), "points", len(wspPoints))
+			migrationData.progress.PointsWritten(len(wspPoints))
+			ObserveWrite(migrationData.option, start, len(wspPoints))
+			migrationData.report.Written(wspFile)
+			return nil
+		})
+	if closeErr := sink.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Line protocol points, second precision, for the given series
+func NewSeriesPoints(mtf *MTF, wspPoints []whisper.Point) ([]*client.Point, error) {
+	tags := make(map[string]string)
+	for _, tagConfigTag := range mtf.Tags {
+		tags[tagConfigTag.Tagkey] = tagConfigTag.Tagvalue
+	}
+	pts := make([]*client.Point, 0, len(wspPoints))
+	for _, wspPoint := range wspPoints {
+		value, err := InfluxDBValue(mtf.Type, wspPoint.Value)
+		if err != nil {
+			return nil, err
+		}
+		fields := map[string]interface{}{mtf.Field: value}
+		pt, err := client.NewPoint(mtf.Measurement, tags, fields,
+			time.Unix(int64(wspPoint.Timestamp), 0))
+		if err != nil {
+			return nil, err
+		}
+		pts = append(pts, pt)
+	}
+	return pts, nil
+}
+
+// Longest retention of all whisper files, used to size retention policies
+// and buckets. Ceres nodes have no fixed retention, 0 is returned for them
+func (migrationData *MigrationData) MaxRetention() time.Duration {
+	if migrationData.source == "ceres" {
+		return 0
+	}
+	var maxRetention uint32
+	migrationData.EachWhisperFile(func(wspFile string, r io.ReaderAt, size int64) error {
+		header, err := ReadWhisperHeader(r)
+		if err == nil && header.MaxRetention > maxRetention {
+			maxRetention = header.MaxRetention
+		}
+		return nil
+	})
+	return time.Duration(maxRetention) * time.Second
+}
+
+// Call fn until it succeeds, returns an error which is not retryable or has
+// failed maxRetries+1 times. The wait between attempts doubles from a second
+// up to a minute. Once ctx is canceled fn is not retried, the last error is
+// returned at once
+func DoWithRetry(ctx context.Context, maxRetries int,
+	fn func() (retryable bool, err error)) error {
+
+	wait := time.Second
+	for attempt := 0; ; attempt++ {
+		retryable, err := fn()
+		if err == nil || !retryable || attempt >= maxRetries || ctx.Err() != nil {
+			return err
+		}
+		level.Warn(logger).Log("msg", "Write failed, retrying", "phase", "write",
+			"attempt", attempt+1, "wait", wait, "err", err)
+		writeRetries.Inc()
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		if wait = wait * 2; wait > time.Minute {
+			wait = time.Minute
+		}
+	}
+}
+
+// Send req and wait for the response, for DoWithRetry. Network errors, 429
+// and 5xx responses are retryable, any other failure is not. The error of a
+// failure carries the status and the start of the response body
+func SendHTTP(client *http.Client, req *http.Request) (bool, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return false, nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("%s %s", resp.Status, bytes.TrimSpace(msg))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5, err
+}
+
+// Limits the points a sink sends to rate per second on average. A nil
+// RateLimiter or a rate of 0 does not limit
+type RateLimiter struct {
+	rate  float64
+	start time.Time
+	sent  int
+}
+
+func NewRateLimiter(rate float64) *RateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &RateLimiter{rate: rate}
+}
+
+// Wait until n more points may be sent
+func (limiter *RateLimiter) Wait(n int) {
+	if limiter == nil {
+		return
+	}
+	if limiter.start.IsZero() {
+		limiter.start = time.Now()
+	}
+	due := limiter.start.Add(time.Duration(float64(limiter.sent) / limiter.rate *
+		float64(time.Second)))
+	time.Sleep(time.Until(due))
+	limiter.sent = limiter.sent + n
+}
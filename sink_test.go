@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDoWithRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries int
+		failures   int
+		retryable  bool
+		calls      int
+		err        bool
+	}{
+		{"success", 3, 0, true, 1, false},
+		{"not retryable", 3, 5, false, 1, true},
+		{"retried", 3, 1, true, 2, false},
+		{"no retries", 0, 5, true, 1, true},
+	}
+	for _, test := range tests {
+		calls := 0
+		err := DoWithRetry(context.Background(), test.maxRetries, func() (bool, error) {
+			calls++
+			if calls <= test.failures {
+				return test.retryable, errors.New("failed")
+			}
+			return false, nil
+		})
+		if (err != nil) != test.err || calls != test.calls {
+			t.Errorf("%s: err %v after %d calls, want %d calls", test.name, err, calls, test.calls)
+		}
+	}
+}
+
+func TestDoWithRetryStopsWaitingOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	calls := 0
+	err := DoWithRetry(ctx, 5, func() (bool, error) {
+		calls++
+		return true, errors.New("unavailable")
+	})
+	if err == nil || calls != 1 {
+		t.Fatalf("err %v after %d calls", err, calls)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("returned after %s", elapsed)
+	}
+
+	//a canceled migration still sends its last batch once
+	calls = 0
+	DoWithRetry(ctx, 5, func() (bool, error) {
+		calls++
+		return true, errors.New("unavailable")
+	})
+	if calls != 1 {
+		t.Fatalf("%d calls after cancel", calls)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-kit/log/level"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Like time.ParseDuration, but also accepts the d (day), w (week) and
+// y (365 days) units used in Graphite retentions, e.g. 90d or 2y
+func ParseDuration(s string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+		"y": 365 * 24 * time.Hour,
+	}
+	for suffix, unit := range units {
+		if strings.HasSuffix(s, suffix) {
+			n, err := strconv.ParseFloat(strings.TrimSuffix(s, suffix), 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %s", s)
+			}
+			return time.Duration(n * float64(unit)), nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+// Exclude the whisper files whose newest point is older than maxAge and write
+// each excluded file with its last update to the report file
+func (migrationData *MigrationData) SkipStaleFiles(maxAge time.Duration,
+	reportFile string) error {
+
+	report, err := os.Create(reportFile)
+	if err != nil {
+		return err
+	}
+	defer report.Close()
+
+	cutoff := time.Now().Add(-maxAge)
+	stale := make(map[string]bool)
+	check := func(wspFile string, last uint32) error {
+		if !time.Unix(int64(last), 0).Before(cutoff) {
+			return nil
+		}
+		lastUpdate := "never"
+		if last != 0 {
+			lastUpdate = time.Unix(int64(last), 0).UTC().Format(time.RFC3339)
+		}
+		stale[wspFile] = true
+		_, err := fmt.Fprintf(report, "%s\t%s\n", wspFile, lastUpdate)
+		return err
+	}
+
+	if migrationData.source == "ceres" {
+		for _, node := range migrationData.wspFiles {
+			if migrationData.IsQuarantined(node) {
+				continue
+			}
+			last, err := CeresLastUpdate(node)
+			if err != nil {
+				migrationData.Quarantine(node, err)
+				continue
+			}
+			if err := check(node, last); err != nil {
+				return err
+			}
+		}
+	} else {
+		err = migrationData.EachWhisperFile(func(wspFile string, r io.ReaderAt, size int64) error {
+			header, err := ReadWhisperHeader(r)
+			if err != nil {
+				migrationData.Quarantine(wspFile, err)
+				return nil
+			}
+			last, err := header.LastUpdate(r)
+			if err != nil {
+				migrationData.Quarantine(wspFile, err)
+				return nil
+			}
+			return check(wspFile, last)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	wspFiles := make([]string, 0, len(migrationData.wspFiles))
+	for _, wspFile := range migrationData.wspFiles {
+		if migrationData.IsQuarantined(wspFile) {
+			continue
+		}
+		if !stale[wspFile] {
+			wspFiles = append(wspFiles, wspFile)
+			continue
+		}
+		migrationData.report.FileSkipped(wspFile, "stale")
+		migrationData.whisperFileSize = migrationData.whisperFileSize -
+			migrationData.wspFileSizes[wspFile]
+	}
+	migrationData.wspFiles = wspFiles
+	migrationData.staleFiles = len(stale)
+	level.Info(logger).Log("msg", "Skipped stale whisper files", "phase", "scan",
+		"count", migrationData.staleFiles, "report", reportFile)
+	return nil
+}
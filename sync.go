@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-kit/log/level"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Options which can keep writing in sync mode, all write over HTTP or TCP
+func IsSyncOption(option string) bool {
+	switch option {
+	case "ClientV2", "InfluxDB2", "PromRemoteWrite", "OpenTSDB", "Graphite":
+		return true
+	}
+	return false
+}
+
+// Timestamp of the newest point synced of every whisper file, kept in the
+// -sync-state file so that a restarted sync continues where it stopped
+type SyncState map[string]uint32
+
+func ReadSyncState(filename string) (SyncState, error) {
+	state := make(SyncState)
+	raw, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return state, json.Unmarshal(raw, &state)
+}
+
+// Write the state to a temporary file first, a crash never leaves a partial
+// state file behind
+func (state SyncState) Write(filename string) error {
+	raw, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filename+".tmp", raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+// Keep writing the points carbon adds to the whisper files, until the
+// process is stopped. Every -sync-interval the whisper files changed since
+// the last pass, or with -sync-inotify the ones inotify reported, are read
+// from their last synced point on. Whisper files not in the state file, new
+// metrics, are read from -from on. New whisper files no pattern of the tag
+// config matches are skipped, there is nobody to prompt for a pattern
+func (migrationData *MigrationData) Sync(wspPath string) error {
+	migrationData.unmatched = make(map[string]bool)
+	state, err := ReadSyncState(migrationData.syncState)
+	if err != nil {
+		return fmt.Errorf("Error in reading the sync state : %s", err)
+	}
+	//The initial migration wrote up to until
+	for _, wspFile := range migrationData.wspFiles {
+		if _, ok := state[wspFile]; !ok {
+			state[wspFile] = uint32(migrationData.until.Unix())
+		}
+	}
+	if err := state.Write(migrationData.syncState); err != nil {
+		return err
+	}
+
+	var changed func() []string
+	if migrationData.syncInotify {
+		watcher, err := NewWhisperWatcher(wspPath)
+		if err != nil {
+			return err
+		}
+		defer watcher.Close()
+		changed = func() []string {
+			var wspFiles []string
+			for _, wspFile := range watcher.Changed() {
+				if migrationData.filter.MatchName(MetricName(wspPath, wspFile)) {
+					wspFiles = append(wspFiles, wspFile)
+				}
+			}
+			return wspFiles
+		}
+	} else {
+		lastPass := time.Now()
+		changed = func() []string {
+			since := lastPass
+			lastPass = time.Now()
+			return migrationData.ChangedWhisperFiles(wspPath, since)
+		}
+	}
+
+	done := migrationData.Context().Done()
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-time.After(migrationData.syncInterval):
+		}
+		wspFiles := changed()
+		if len(wspFiles) == 0 {
+			continue
+		}
+		if err := migrationData.syncPass(wspFiles, state); err != nil {
+			level.Error(logger).Log("msg", "Sync failed, retrying with the next pass",
+				"phase", "sync", "err", err)
+		}
+	}
+}
+
+// Find the whisper files again and return the ones modified after since
+func (migrationData *MigrationData) ChangedWhisperFiles(wspPath string,
+	since time.Time) []string {
+
+	migrationData.wspFiles = nil
+	migrationData.whisperFileSize = 0
+	migrationData.FindWhisperFiles(wspPath)
+	var changed []string
+	for _, wspFile := range migrationData.wspFiles {
+		if fileinfo, err := os.Stat(wspFile); err == nil && !fileinfo.ModTime().Before(since) {
+			changed = append(changed, wspFile)
+		}
+	}
+	return changed
+}
+
+// Write the points from the last synced one on of the whisper files. The state
+// is only saved once the sink has written everything, a failed pass is read
+// again by the next one
+func (migrationData *MigrationData) syncPass(wspFiles []string, state SyncState) error {
+	sink, err := migrationData.NewSink()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	synced := make(SyncState)
+	for _, wspFile := range wspFiles {
+		//on a signal the files synced so far are written and saved
+		if migrationData.Canceled() != nil {
+			break
+		}
+		//a quarantined file stays quarantined, it is not validated and listed
+		//again
+		if migrationData.IsQuarantined(wspFile) || migrationData.unmatched[wspFile] {
+			continue
+		}
+		from := migrationData.from
+		resample := migrationData.ResampleOf(wspFile)
+		if last, ok := state[wspFile]; ok {
+			//carbon keeps updating the newest slot until the next one starts,
+			//the last synced point is read again and written with its final
+			//value
+			from = time.Unix(int64(last)-1, 0)
+			//the bucket of the last synced point was written with the points
+			//known then, it is read whole and written again
+			if resample != nil {
+				from = time.Unix(int64(resample.Bucket(last)), 0)
+			}
+		} else if err := migrationData.ValidateNewWhisperFile(wspFile); err != nil {
+			migrationData.Quarantine(wspFile, err)
+			continue
+		} else if migrationData.GetMTF(wspFile) == nil {
+			level.Warn(logger).Log("msg", "Skipping whisper file, no pattern of the tag config matches",
+				"phase", "sync", "file", wspFile)
+			migrationData.unmatched[wspFile] = true
+			migrationData.report.FileSkipped(wspFile, "no matching tag config")
+			continue
+		}
+		fetchFrom, fetchUntil := from, now
+		if resample != nil {
+			fetchFrom, fetchUntil = resample.FetchRange(from, now)
+		}
+		wspPoints, err := FetchSyncPoints(migrationData.source, wspFile, fetchFrom,
+			fetchUntil)
+		if err != nil {
+			migrationErrors.WithLabelValues("read").Inc()
+			level.Warn(logger).Log("msg", "Could not read", "phase", "sync",
+				"file", wspFile, "err", err)
+			continue
+		}
+		migrationData.FileRead(0)
+		pointsRead.Add(float64(len(wspPoints)))
+		if resample != nil {
+			wspPoints = resample.WindowPoints(wspPoints, from, now)
+		}
+		if len(wspPoints) == 0 {
+			continue
+		}
+		synced[wspFile] = wspPoints[len(wspPoints)-1].Timestamp
+		mtf, wspPoints := migrationData.MapPoints(wspFile, wspPoints)
+		start := time.Now()
+		if err := sink.WriteSeries(mtf, wspPoints); err != nil {
+			migrationErrors.WithLabelValues("write").Inc()
+			sink.Close()
+			migrationData.report.NotWritten()
+			return err
+		}
+		ObserveWrite(migrationData.option, start, len(wspPoints))
+	}
+	//the sinks batch, the points are only written once the sink is closed
+	if err := sink.Close(); err != nil {
+		migrationData.report.NotWritten()
+		return err
+	}
+	migrationData.report.AllWritten()
+	for wspFile, last := range synced {
+		state[wspFile] = last
+	}
+	level.Info(logger).Log("msg", "Synced whisper files", "phase", "sync",
+		"count", len(synced), "unmatched", len(migrationData.unmatched), "until", now)
+	return state.Write(migrationData.syncState)
+}
+
+// Validate a whisper file which appeared during sync, as ValidateWhisperFiles
+// does for the ones found at the start
+func (migrationData *MigrationData) ValidateNewWhisperFile(wspFile string) error {
+	if migrationData.source == "ceres" {
+		metadata, err := ValidateCeresNode(wspFile)
+		if err == nil {
+			migrationData.resolutions[wspFile] = metadata.TimeStep
+		}
+		return err
+	}
+	f, err := os.Open(wspFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fileinfo, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := ValidateWhisperData(f, fileinfo.Size())
+	if err == nil {
+		migrationData.resolutions[wspFile] = header.Archives[0].SecondsPerPoint
+	}
+	return err
+}
+
+// Points of a whisper file, or Ceres node, after from
+func FetchSyncPoints(source string, wspFile string, from time.Time,
+	until time.Time) ([]whisper.Point, error) {
+	if source == "ceres" {
+		return FetchCeresPoints(wspFile, from, until)
+	}
+	return FetchWhisperPoints(wspFile, from, until, nil)
+}
+
+// Collects the whisper files written to under a folder with inotify
+type WhisperWatcher struct {
+	watcher *fsnotify.Watcher
+	mu      sync.Mutex
+	changed map[string]bool
+}
+
+// Watch every folder under wspPath, folders created later are added when
+// they appear
+func NewWhisperWatcher(wspPath string) (*WhisperWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &WhisperWatcher{watcher: watcher, changed: make(map[string]bool)}
+	if err := w.addTree(wspPath); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+func (w *WhisperWatcher) addTree(dir string) error {
+	return filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
+		if err != nil || !f.IsDir() {
+			return nil
+		}
+		if path != dir && IsHiddenOrTemp(f.Name()) {
+			return filepath.SkipDir
+		}
+		return w.watcher.Add(path)
+	})
+}
+
+func (w *WhisperWatcher) run() {
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&fsnotify.Create != 0 {
+				if f, err := os.Stat(event.Name); err == nil && f.IsDir() {
+					w.addTree(event.Name)
+					continue
+				}
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create) != 0 &&
+				IsWhisperFileName(filepath.Base(event.Name)) {
+				w.mu.Lock()
+				w.changed[event.Name] = true
+				w.mu.Unlock()
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			level.Error(logger).Log("msg", "Error in watching whisper files",
+				"phase", "sync", "err", err)
+		}
+	}
+}
+
+// Whisper files written to since the last call
+func (w *WhisperWatcher) Changed() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var changed []string
+	for wspFile := range w.changed {
+		changed = append(changed, wspFile)
+	}
+	w.changed = make(map[string]bool)
+	return changed
+}
+
+func (w *WhisperWatcher) Close() error {
+	return w.watcher.Close()
+}
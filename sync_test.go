@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncState(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "sync_state.json")
+	state, err := ReadSyncState(filename)
+	if err != nil || len(state) != 0 {
+		t.Fatalf("missing state file: %v, %v", state, err)
+	}
+	state = SyncState{"a.wsp": 660, "b.wsp": 720}
+	if err := state.Write(filename); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filename + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary state file left behind: %v", err)
+	}
+	read, err := ReadSyncState(filename)
+	if err != nil || len(read) != 2 || read["a.wsp"] != 660 || read["b.wsp"] != 720 {
+		t.Errorf("read state %v, %v", read, err)
+	}
+	if err := ioutil.WriteFile(filename, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadSyncState(filename); err == nil {
+		t.Errorf("corrupt state file read")
+	}
+}
+
+func writeCeresNode(t *testing.T, node string, values ...float64) {
+	if err := os.MkdirAll(node, 0755); err != nil {
+		t.Fatal(err)
+	}
+	err := ioutil.WriteFile(filepath.Join(node, ceresNodeFile), []byte(`{"timeStep": 60}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeCeresSlice(t, node, "600@60.slice", values...)
+}
+
+func TestSyncPass(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	lines := make(chan []string)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(lines)
+			return
+		}
+		defer conn.Close()
+		var received []string
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			received = append(received, scanner.Text())
+		}
+		lines <- received
+	}()
+
+	dir := t.TempDir()
+	synced := filepath.Join(dir, "servers", "a")
+	quarantined := filepath.Join(dir, "servers", "c")
+	unmatched := filepath.Join(dir, "other", "b")
+	for _, node := range []string{synced, quarantined, unmatched} {
+		writeCeresNode(t, node, 1, 2, 3)
+	}
+	migrationData := &MigrationData{source: "ceres", option: "Graphite",
+		graphiteAddr: listener.Addr().String(), quarantineList: "NULL",
+		syncState:   filepath.Join(dir, "sync_state.json"),
+		from:        time.Unix(0, 0),
+		tagConfigs:  []TagConfig{{Pattern: `servers\.`, Field: "value"}},
+		unmatched:   make(map[string]bool),
+		resolutions: make(map[string]uint32)}
+	migrationData.Quarantine(quarantined, fmt.Errorf("corrupt"))
+
+	//the point at 660 was synced before carbon updated it, it is written again
+	state := SyncState{synced: 660, quarantined: 600}
+	if err := migrationData.syncPass([]string{synced, quarantined, unmatched}, state); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a.value 2 660", "a.value 3 720"}
+	if got := <-lines; strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("lines %q, want %q", got, want)
+	}
+	if !migrationData.unmatched[unmatched] {
+		t.Errorf("%s not skipped as unmatched", unmatched)
+	}
+	saved, err := ReadSyncState(migrationData.syncState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved[synced] != 720 || saved[quarantined] != 600 || len(saved) != 2 {
+		t.Errorf("saved state %v", saved)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/go-kit/log/level"
+	"github.com/parquet-go/parquet-go"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rows buffered by the Parquet sink before every partition is written out
+const parquetBufferRows = 1000000
+
+// A row of the CSV and Parquet exports, tags holds one value per tag column
+type tableRow struct {
+	tags      []string
+	field     string
+	timestamp uint32
+	value     float64
+}
+
+// Exports are laid out as <dir>/<measurement>/day=<YYYY-MM-DD>/
+type tablePartition struct {
+	measurement string
+	day         string
+}
+
+// Tag columns of the CSV and Parquet exports, the union of the tag keys of all
+// tag configs and the default tags so every file of an export has the same
+// columns
+func (migrationData *MigrationData) TagKeys() []string {
+	seen := make(map[string]bool)
+	var tagKeys []string
+	add := func(tags []TagKeyValue) {
+		for _, tag := range tags {
+			if tag.Tagkey != "" && !seen[tag.Tagkey] {
+				seen[tag.Tagkey] = true
+				tagKeys = append(tagKeys, tag.Tagkey)
+			}
+		}
+	}
+	for _, tagConfig := range migrationData.tagConfigs {
+		add(tagConfig.Tags)
+	}
+	add(migrationData.defaultTags)
+	sort.Strings(tagKeys)
+	return tagKeys
+}
+
+// Column names: measurement, the tag keys, field, timestamp, value. A tag key
+// clashing with one of the fixed columns is prefixed with tag_
+func tableColumns(tagKeys []string) []string {
+	columns := []string{"measurement"}
+	for _, tagKey := range tagKeys {
+		switch tagKey {
+		case "measurement", "field", "timestamp", "value":
+			tagKey = "tag_" + tagKey
+		}
+		columns = append(columns, tagKey)
+	}
+	return append(columns, "field", "timestamp", "value")
+}
+
+// Split the points of a series into rows per measurement and day
+func seriesRows(mtf *MTF, tagKeys []string,
+	wspPoints []whisper.Point) map[tablePartition][]tableRow {
+
+	tags := make([]string, len(tagKeys))
+	for i, tagKey := range tagKeys {
+		for _, tag := range mtf.Tags {
+			if tag.Tagkey == tagKey {
+				tags[i] = tag.Tagvalue
+			}
+		}
+	}
+	rows := make(map[tablePartition][]tableRow)
+	for _, wspPoint := range wspPoints {
+		partition := tablePartition{
+			measurement: mtf.Measurement,
+			day:         time.Unix(int64(wspPoint.Timestamp), 0).UTC().Format("2006-01-02"),
+		}
+		rows[partition] = append(rows[partition], tableRow{tags: tags,
+			field: mtf.Field, timestamp: wspPoint.Timestamp, value: wspPoint.Value})
+	}
+	return rows
+}
+
+// Measurement made safe to use as a file name
+func (partition tablePartition) name() string {
+	name := strings.Replace(partition.measurement, string(os.PathSeparator), "_", -1)
+	if name == "" || strings.HasPrefix(name, ".") {
+		name = "_" + name
+	}
+	return name
+}
+
+func (partition tablePartition) dir(root string) string {
+	return filepath.Join(root, partition.name(), "day="+partition.day)
+}
+
+// Appends rows to one CSV file per measurement and day
+type CSVSink struct {
+	dir     string
+	tagKeys []string
+}
+
+func NewCSVSink(dir string, tagKeys []string) *CSVSink {
+	return &CSVSink{dir: dir, tagKeys: tagKeys}
+}
+
+func (sink *CSVSink) WriteSeries(mtf *MTF, wspPoints []whisper.Point) error {
+	for partition, rows := range seriesRows(mtf, sink.tagKeys, wspPoints) {
+		if err := sink.append(partition, rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sink *CSVSink) append(partition tablePartition, rows []tableRow) error {
+	dir := partition.dir(sink.dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, partition.name()+".csv"),
+		os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fileinfo, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(f)
+	if fileinfo.Size() == 0 {
+		w.Write(tableColumns(sink.tagKeys))
+	}
+	record := make([]string, 0, len(sink.tagKeys)+4)
+	for _, row := range rows {
+		record = append(record[:0], partition.measurement)
+		record = append(record, row.tags...)
+		record = append(record, row.field,
+			time.Unix(int64(row.timestamp), 0).UTC().Format(time.RFC3339),
+			strconv.FormatFloat(row.value, 'g', -1, 64))
+		w.Write(record)
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func (sink *CSVSink) Close() error {
+	return nil
+}
+
+// Writes one Parquet file per measurement and day. Parquet files can not be
+// appended to, so rows are buffered and a partition only gets more than one
+// part file when the buffer fills up before the migration ends
+type ParquetSink struct {
+	dir      string
+	tagKeys  []string
+	schema   *parquet.Schema
+	rows     map[tablePartition][]tableRow
+	buffered int
+	parts    map[tablePartition]int
+}
+
+func NewParquetSink(dir string, tagKeys []string) *ParquetSink {
+	group := parquet.Group{}
+	for i, column := range tableColumns(tagKeys) {
+		switch {
+		case column == "timestamp":
+			group[column] = parquet.Timestamp(parquet.Millisecond)
+		case column == "value":
+			group[column] = parquet.Leaf(parquet.DoubleType)
+		case i > 0 && i <= len(tagKeys): //tag columns
+			group[column] = parquet.Optional(parquet.String())
+		default:
+			group[column] = parquet.String()
+		}
+	}
+	return &ParquetSink{
+		dir:     dir,
+		tagKeys: tagKeys,
+		schema:  parquet.NewSchema("whisper", group),
+		rows:    make(map[tablePartition][]tableRow),
+		parts:   make(map[tablePartition]int),
+	}
+}
+
+func (sink *ParquetSink) WriteSeries(mtf *MTF, wspPoints []whisper.Point) error {
+	for partition, rows := range seriesRows(mtf, sink.tagKeys, wspPoints) {
+		sink.rows[partition] = append(sink.rows[partition], rows...)
+		sink.buffered = sink.buffered + len(rows)
+	}
+	if sink.buffered >= parquetBufferRows {
+		return sink.flush()
+	}
+	return nil
+}
+
+func (sink *ParquetSink) flush() error {
+	for partition, rows := range sink.rows {
+		if err := sink.writePart(partition, rows); err != nil {
+			return err
+		}
+		delete(sink.rows, partition)
+	}
+	sink.buffered = 0
+	return nil
+}
+
+func (sink *ParquetSink) writePart(partition tablePartition, rows []tableRow) error {
+	dir := partition.dir(sink.dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	sink.parts[partition] = sink.parts[partition] + 1
+	filename := filepath.Join(dir,
+		fmt.Sprintf("%s-%04d.parquet", partition.name(), sink.parts[partition]))
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	//Group sorts its fields by name, so look the column indexes up
+	index := make(map[string]int)
+	for i, path := range sink.schema.Columns() {
+		index[path[0]] = i
+	}
+	columns := tableColumns(sink.tagKeys)
+	w := parquet.NewWriter(f, sink.schema)
+	batch := make([]parquet.Row, 0, len(rows))
+	for _, row := range rows {
+		values := make(parquet.Row, len(columns))
+		values[index["measurement"]] = parquet.ValueOf(partition.measurement).Level(0, 0, index["measurement"])
+		for i, tag := range row.tags {
+			column := index[columns[i+1]]
+			if tag == "" {
+				values[column] = parquet.NullValue().Level(0, 0, column)
+			} else {
+				values[column] = parquet.ValueOf(tag).Level(0, 1, column)
+			}
+		}
+		values[index["field"]] = parquet.ValueOf(row.field).Level(0, 0, index["field"])
+		values[index["timestamp"]] = parquet.ValueOf(int64(row.timestamp)*1000).Level(0, 0, index["timestamp"])
+		values[index["value"]] = parquet.ValueOf(row.value).Level(0, 0, index["value"])
+		batch = append(batch, values)
+	}
+	if _, err := w.WriteRows(batch); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	level.Info(logger).Log("msg", "Parquet file written", "phase", "write",
+		"file", filename, "rows", len(rows))
+	return nil
+}
+
+func (sink *ParquetSink) Close() error {
+	return sink.flush()
+}
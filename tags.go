@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Parse tags given as key=value,key=value
+func ParseTags(s string) ([]TagKeyValue, error) {
+	var tags []TagKeyValue
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid tag %q, want key=value", pair)
+		}
+		tags = append(tags, TagKeyValue{Tagkey: parts[0], Tagvalue: parts[1]})
+	}
+	return tags, nil
+}
+
+// Complete the tags of a series. The tag values $depth and $resolution are
+// replaced with the number of folders above the whisper file under wspPath
+// and the interval of its highest precision archive, e.g. 60s. The
+// -default-tags are added unless the tag config gives the same key. Tags
+// without key or value are left out and the rest are sorted by key, as
+// InfluxDB keeps them in series keys
+func (migrationData *MigrationData) ResolveTags(wspFile string, mtf *MTF) {
+	var candidates []TagKeyValue
+	candidates = append(candidates, mtf.Tags...)
+	candidates = append(candidates, migrationData.defaultTags...)
+	tags := make([]TagKeyValue, 0, len(candidates))
+	seen := make(map[string]bool)
+	for _, tag := range candidates {
+		switch tag.Tagvalue {
+		case "$depth":
+			metric := MetricName(migrationData.wspPath, wspFile)
+			tag.Tagvalue = strconv.Itoa(strings.Count(metric, "."))
+		case "$resolution":
+			tag.Tagvalue = ""
+			if resolution := migrationData.resolutions[wspFile]; resolution > 0 {
+				tag.Tagvalue = fmt.Sprintf("%ds", resolution)
+			}
+		}
+		if tag.Tagkey == "" || tag.Tagvalue == "" || seen[tag.Tagkey] {
+			continue
+		}
+		seen[tag.Tagkey] = true
+		tags = append(tags, tag)
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].Tagkey < tags[j].Tagkey })
+	mtf.Tags = tags
+}
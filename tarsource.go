@@ -0,0 +1,160 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// Whisper trees can be read straight from a .tar, .tar.gz or .tar.zst backup
+func IsTarArchive(wspPath string) bool {
+	for _, suffix := range []string{".tar", ".tar.gz", ".tgz", ".tar.zst"} {
+		if strings.HasSuffix(wspPath, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Stream the archive and call fn for every regular .wsp entry. The entry name
+// is cleaned, so ./carbon/agents/x.wsp is passed as carbon/agents/x.wsp
+func WalkTarArchive(archive string,
+	fn func(name string, hdr *tar.Header, r io.Reader) error) error {
+
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	switch {
+	case strings.HasSuffix(archive, ".gz") || strings.HasSuffix(archive, ".tgz"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	case strings.HasSuffix(archive, ".zst"):
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading %s: %s", archive, err)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if !strings.HasSuffix(name, ".wsp") {
+			continue
+		}
+		if err := fn(name, hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// Find the whisper entries of a tar archive which pass the filters
+func (migrationData *MigrationData) FindArchiveWhisperFiles(archive string) error {
+	filter := migrationData.filter
+	if filter == nil {
+		filter = &FileFilter{}
+	}
+	migrationData.wspArchive = archive
+	return WalkTarArchive(archive, func(name string, hdr *tar.Header, r io.Reader) error {
+		//e.g. ._cpu.wsp, the resource fork macOS tar adds next to cpu.wsp
+		if !IsWhisperFileName(path.Base(name)) {
+			return nil
+		}
+		for _, dir := range strings.Split(path.Dir(name), "/") {
+			if dir != "." && IsHiddenOrTemp(dir) {
+				return nil
+			}
+		}
+		if !filter.MatchName(MetricName(".", name)) ||
+			!filter.MatchMtime(hdr.ModTime) {
+			return nil
+		}
+		migrationData.AddWhisperFile(name, hdr.Size)
+		return nil
+	})
+}
+
+// Call fn with the contents of every whisper file still to be migrated, in
+// the order of wspFiles. Files are opened from disk or, when wspPath is a tar
+// archive, read into memory one entry at a time while streaming the archive.
+// A file which can not be read is quarantined, an error returned by fn stops
+// the iteration
+func (migrationData *MigrationData) EachWhisperFile(
+	fn func(wspFile string, r io.ReaderAt, size int64) error) error {
+
+	if migrationData.wspArchive == "" {
+		for _, wspFile := range migrationData.wspFiles {
+			if err := migrationData.Canceled(); err != nil {
+				return err
+			}
+			if migrationData.IsQuarantined(wspFile) {
+				continue
+			}
+			f, err := os.Open(wspFile)
+			if err != nil {
+				migrationData.Quarantine(wspFile, err)
+				continue
+			}
+			fileinfo, err := f.Stat()
+			if err != nil {
+				f.Close()
+				migrationData.Quarantine(wspFile, err)
+				continue
+			}
+			err = fn(wspFile, f, fileinfo.Size())
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	wanted := make(map[string]bool, len(migrationData.wspFiles))
+	for _, wspFile := range migrationData.wspFiles {
+		wanted[wspFile] = !migrationData.IsQuarantined(wspFile)
+	}
+	return WalkTarArchive(migrationData.wspArchive,
+		func(name string, hdr *tar.Header, r io.Reader) error {
+			if !wanted[name] {
+				return nil
+			}
+			if err := migrationData.Canceled(); err != nil {
+				return err
+			}
+			//a name appearing twice in the archive is only read once
+			delete(wanted, name)
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				migrationData.Quarantine(name, err)
+				return nil
+			}
+			return fn(name, bytes.NewReader(data), int64(len(data)))
+		})
+}
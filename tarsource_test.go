@@ -0,0 +1,39 @@
+package main
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindArchiveWhisperFiles(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "whisper.tar")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	for _, name := range []string{"whisper/cpu.wsp", "whisper/._cpu.wsp",
+		"whisper/.trash/mem.wsp", "whisper/cpu.wsp.tmp", "whisper/notes.txt"} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: 1,
+			Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte{0}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	migrationData := &MigrationData{}
+	if err := migrationData.FindArchiveWhisperFiles(archive); err != nil {
+		t.Fatal(err)
+	}
+	if len(migrationData.wspFiles) != 1 || migrationData.wspFiles[0] != "whisper/cpu.wsp" {
+		t.Errorf("wspFiles %v, want [whisper/cpu.wsp]", migrationData.wspFiles)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+	"math"
+	"strings"
+	"time"
+)
+
+// Layouts accepted for -from and -until besides RFC3339, in the -tz location
+var timeLayouts = []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+
+// Parse a -from or -until value: now, a duration relative to now like -90d or
+// now-12h, an RFC3339 timestamp, or a date or date and time in loc
+func ParseTime(value string, loc *time.Location, now time.Time) (time.Time, error) {
+	if value == "now" {
+		return now, nil
+	}
+	relative := strings.TrimPrefix(value, "now")
+	if strings.HasPrefix(relative, "-") || strings.HasPrefix(relative, "+") {
+		d, err := ParseDuration(strings.TrimPrefix(relative, "+"))
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %s, want YYYY-MM-DD, RFC3339 or e.g. -90d", value)
+}
+
+// Move the points by -time-shift. Points shifted out of the range of whisper
+// timestamps are left out
+func (migrationData *MigrationData) ShiftPoints(wspPoints []whisper.Point) []whisper.Point {
+	if migrationData.timeShift == 0 {
+		return wspPoints
+	}
+	shift := int64(migrationData.timeShift / time.Second)
+	points := make([]whisper.Point, 0, len(wspPoints))
+	for _, wspPoint := range wspPoints {
+		timestamp := int64(wspPoint.Timestamp) + shift
+		if timestamp < 0 || timestamp > math.MaxUint32 {
+			continue
+		}
+		points = append(points, whisper.Point{Timestamp: uint32(timestamp),
+			Value: wspPoint.Value})
+	}
+	return points
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/uttamgandhi24/whisper-go/whisper"
+)
+
+func TestParseTime(t *testing.T) {
+	cet := time.FixedZone("CET", 3600)
+	now := time.Date(2016, 1, 12, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		loc   *time.Location
+		want  time.Time
+		err   bool
+	}{
+		{"now", time.UTC, now, false},
+		{"-90d", time.UTC, now.Add(-90 * 24 * time.Hour), false},
+		{"now-12h", time.UTC, now.Add(-12 * time.Hour), false},
+		{"now+1w", time.UTC, now.Add(7 * 24 * time.Hour), false},
+		{"+30m", time.UTC, now.Add(30 * time.Minute), false},
+		{"2015-11-01T12:00:00+02:00", cet,
+			time.Date(2015, 11, 1, 10, 0, 0, 0, time.UTC), false},
+		{"2015-11-01", time.UTC, time.Date(2015, 11, 1, 0, 0, 0, 0, time.UTC), false},
+		{"2015-11-01", cet, time.Date(2015, 10, 31, 23, 0, 0, 0, time.UTC), false},
+		{"2015-11-01 06:30:00", cet, time.Date(2015, 11, 1, 5, 30, 0, 0, time.UTC), false},
+		{"2015-11-01T06:30:00", time.UTC, time.Date(2015, 11, 1, 6, 30, 0, 0, time.UTC), false},
+		{"-3x", time.UTC, time.Time{}, true},
+		{"01/11/2015", time.UTC, time.Time{}, true},
+		{"yesterday", time.UTC, time.Time{}, true},
+	}
+	for _, test := range tests {
+		got, err := ParseTime(test.value, test.loc, now)
+		if (err != nil) != test.err {
+			t.Errorf("ParseTime(%q) err %v", test.value, err)
+			continue
+		}
+		if !got.Equal(test.want) {
+			t.Errorf("ParseTime(%q) = %s, want %s", test.value, got, test.want)
+		}
+	}
+}
+
+func TestShiftPoints(t *testing.T) {
+	points := []whisper.Point{{Timestamp: 100, Value: 1}, {Timestamp: 4000, Value: 2}}
+	tests := []struct {
+		shift time.Duration
+		want  []uint32
+	}{
+		{0, []uint32{100, 4000}},
+		{time.Hour, []uint32{3700, 7600}},
+		{-time.Hour, []uint32{400}},
+	}
+	for _, test := range tests {
+		migrationData := &MigrationData{timeShift: test.shift}
+		got := migrationData.ShiftPoints(points)
+		if len(got) != len(test.want) {
+			t.Errorf("shift %s: %v", test.shift, got)
+			continue
+		}
+		for i := range got {
+			if got[i].Timestamp != test.want[i] || got[i].Value != points[len(points)-len(got)+i].Value {
+				t.Errorf("shift %s: %v, want %v", test.shift, got, test.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+	"math"
+	"strings"
+)
+
+// One step of the transform pipeline of a tag config, e.g.
+// {"type": "scale", "value": 8} to turn bytes into bits
+//
+//	scale      multiply by value
+//	offset     add value
+//	derivative difference to the previous point, negative ones are left out
+//	rate       derivative per second, for counters
+//	clamp      limit to min and max
+//	drop       leave out the points below min or above max
+type Transform struct {
+	Type  string   `json:"type"`
+	Value float64  `json:"value,omitempty"`
+	Min   *float64 `json:"min,omitempty"`
+	Max   *float64 `json:"max,omitempty"`
+}
+
+// Previous point of a series, for derivative and rate
+type transformState struct {
+	seen  bool
+	point whisper.Point
+}
+
+func (transform Transform) Validate() error {
+	switch transform.Type {
+	case "scale", "offset", "derivative", "rate":
+		return nil
+	case "clamp", "drop":
+		if transform.Min == nil && transform.Max == nil {
+			return fmt.Errorf("%s needs min or max", transform.Type)
+		}
+		if transform.Min != nil && transform.Max != nil && *transform.Min > *transform.Max {
+			return fmt.Errorf("%s min is above max", transform.Type)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown transform %q", transform.Type)
+}
+
+func (transform Transform) String() string {
+	switch transform.Type {
+	case "scale", "offset":
+		return fmt.Sprintf("%s(%v)", transform.Type, transform.Value)
+	case "clamp", "drop":
+		min, max := math.Inf(-1), math.Inf(1)
+		if transform.Min != nil {
+			min = *transform.Min
+		}
+		if transform.Max != nil {
+			max = *transform.Max
+		}
+		return fmt.Sprintf("%s(%v,%v)", transform.Type, min, max)
+	}
+	return transform.Type
+}
+
+func FormatTransforms(transforms []Transform) string {
+	var steps []string
+	for _, transform := range transforms {
+		steps = append(steps, transform.String())
+	}
+	return strings.Join(steps, " | ")
+}
+
+// Apply the transform to the value of point, false leaves the point out
+func (transform Transform) apply(state *transformState, point whisper.Point,
+	value float64) (float64, bool) {
+
+	switch transform.Type {
+	case "scale":
+		return value * transform.Value, true
+	case "offset":
+		return value + transform.Value, true
+	case "derivative", "rate":
+		//a point read twice, at the end of one time range and the start of
+		//the next, is only counted once
+		if math.IsNaN(value) || (state.seen && point.Timestamp <= state.point.Timestamp) {
+			return value, false
+		}
+		prev := state.point
+		seen := state.seen
+		state.seen, state.point = true, whisper.Point{Timestamp: point.Timestamp, Value: value}
+		//the first point has nothing to compare to and a decrease is a
+		//counter reset
+		if !seen || value < prev.Value {
+			return value, false
+		}
+		if transform.Type == "rate" {
+			return (value - prev.Value) / float64(point.Timestamp-prev.Timestamp), true
+		}
+		return value - prev.Value, true
+	case "clamp":
+		if transform.Min != nil && value < *transform.Min {
+			value = *transform.Min
+		}
+		if transform.Max != nil && value > *transform.Max {
+			value = *transform.Max
+		}
+		return value, true
+	case "drop":
+		if (transform.Min != nil && value < *transform.Min) ||
+			(transform.Max != nil && value > *transform.Max) {
+			return value, false
+		}
+	}
+	return value, true
+}
+
+// Run the points of a whisper file through the transforms of its tag config.
+// The state of derivative and rate is kept per whisper file, so that a series
+// read in several time ranges, e.g. one per shard, loses no point
+func (migrationData *MigrationData) TransformPoints(wspFile string, mtf *MTF,
+	wspPoints []whisper.Point) []whisper.Point {
+
+	if len(mtf.Transforms) == 0 {
+		return wspPoints
+	}
+	if migrationData.transformStates == nil {
+		migrationData.transformStates = make(map[string][]transformState)
+	}
+	states := migrationData.transformStates[wspFile]
+	if states == nil {
+		states = make([]transformState, len(mtf.Transforms))
+		migrationData.transformStates[wspFile] = states
+	}
+	points := make([]whisper.Point, 0, len(wspPoints))
+	for _, wspPoint := range wspPoints {
+		value, keep := wspPoint.Value, true
+		for i, transform := range mtf.Transforms {
+			if value, keep = transform.apply(&states[i], wspPoint, value); !keep {
+				break
+			}
+		}
+		if keep {
+			points = append(points, whisper.Point{Timestamp: wspPoint.Timestamp, Value: value})
+		}
+	}
+	return points
+}
+
+// Series of a whisper file and its points as they are written: transformed,
+// resampled, without the points not matching the field type, moved by
+// -time-shift and without the points conflicting with data in InfluxDB
+func (migrationData *MigrationData) MapPoints(wspFile string,
+	wspPoints []whisper.Point) (*MTF, []whisper.Point) {
+
+	mtf := migrationData.LookupMTF(wspFile)
+	read := len(wspPoints)
+	step := PointStep(wspPoints)
+	wspPoints = migrationData.TransformPoints(wspFile, mtf, wspPoints)
+	if mtf.Resample != nil {
+		wspPoints = mtf.Resample.Apply(wspPoints, step)
+	}
+	wspPoints = migrationData.RejectLossyPoints(wspFile, mtf, wspPoints)
+	wspPoints = migrationData.ShiftPoints(wspPoints)
+	if migrationData.existing != nil {
+		wspPoints = migrationData.DedupPoints(mtf, wspPoints)
+	}
+	migrationData.report.FileMapped(wspFile, CreateTSMKey(mtf), read, len(wspPoints))
+	return mtf, wspPoints
+}
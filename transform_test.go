@@ -0,0 +1,97 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/uttamgandhi24/whisper-go/whisper"
+)
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+func TestTransformApply(t *testing.T) {
+	type step struct {
+		timestamp uint32
+		value     float64
+		want      float64
+		keep      bool
+	}
+	tests := []struct {
+		name      string
+		transform Transform
+		steps     []step
+	}{
+		{"scale", Transform{Type: "scale", Value: 8},
+			[]step{{60, 2, 16, true}, {120, -1, -8, true}}},
+		{"offset", Transform{Type: "offset", Value: -273.15},
+			[]step{{60, 273.15, 0, true}}},
+		{"derivative", Transform{Type: "derivative"},
+			[]step{{60, 10, 10, false}, {120, 15, 5, true}, {180, 15, 0, true},
+				{240, 3, 3, false}, {300, 4, 1, true}}},
+		{"rate", Transform{Type: "rate"},
+			[]step{{60, 100, 100, false}, {120, 160, 1, true}, {240, 280, 1, true}}},
+		{"rate skips points read twice", Transform{Type: "rate"},
+			[]step{{60, 100, 100, false}, {120, 160, 1, true}, {120, 160, 160, false},
+				{180, 220, 1, true}}},
+		{"derivative skips nulls", Transform{Type: "derivative"},
+			[]step{{60, 1, 1, false}, {120, math.NaN(), 0, false}, {180, 4, 3, true}}},
+		{"clamp", Transform{Type: "clamp", Min: floatPtr(0), Max: floatPtr(100)},
+			[]step{{60, -5, 0, true}, {120, 50, 50, true}, {180, 150, 100, true}}},
+		{"clamp min only", Transform{Type: "clamp", Min: floatPtr(0)},
+			[]step{{60, -5, 0, true}, {120, 1e9, 1e9, true}}},
+		{"drop", Transform{Type: "drop", Min: floatPtr(0), Max: floatPtr(100)},
+			[]step{{60, -5, -5, false}, {120, 50, 50, true}, {180, 150, 150, false}}},
+	}
+	for _, test := range tests {
+		var state transformState
+		for i, s := range test.steps {
+			point := whisper.Point{Timestamp: s.timestamp, Value: s.value}
+			value, keep := test.transform.apply(&state, point, s.value)
+			if keep != s.keep || (keep && value != s.want) {
+				t.Errorf("%s step %d: %v, %v, want %v, %v", test.name, i, value, keep,
+					s.want, s.keep)
+			}
+		}
+	}
+}
+
+func TestTransformValidate(t *testing.T) {
+	tests := []struct {
+		transform Transform
+		err       bool
+	}{
+		{Transform{Type: "scale", Value: 2}, false},
+		{Transform{Type: "rate"}, false},
+		{Transform{Type: "clamp", Max: floatPtr(1)}, false},
+		{Transform{Type: "clamp"}, true},
+		{Transform{Type: "drop", Min: floatPtr(2), Max: floatPtr(1)}, true},
+		{Transform{Type: "log"}, true},
+	}
+	for _, test := range tests {
+		if err := test.transform.Validate(); (err != nil) != test.err {
+			t.Errorf("Validate(%s) err %v", test.transform, err)
+		}
+	}
+}
+
+// Rate across two reads of a whisper file, as with one read per shard
+func TestTransformPointsKeepsStateAcrossReads(t *testing.T) {
+	migrationData := &MigrationData{}
+	mtf := &MTF{Measurement: "net", Field: "bits",
+		Transforms: []Transform{{Type: "rate"}, {Type: "scale", Value: 8}}}
+	first := migrationData.TransformPoints("net.wsp", mtf, []whisper.Point{
+		{Timestamp: 60, Value: 0}, {Timestamp: 120, Value: 60}})
+	second := migrationData.TransformPoints("net.wsp", mtf, []whisper.Point{
+		{Timestamp: 120, Value: 60}, {Timestamp: 180, Value: 180}})
+	if len(first) != 1 || first[0].Timestamp != 120 || first[0].Value != 8 {
+		t.Errorf("first read %v", first)
+	}
+	if len(second) != 1 || second[0].Timestamp != 180 || second[0].Value != 16 {
+		t.Errorf("second read %v", second)
+	}
+	if got := FormatTransforms(mtf.Transforms); got != "rate | scale(8)" {
+		t.Errorf("FormatTransforms = %q", got)
+	}
+}
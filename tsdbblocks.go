@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+	"math"
+	"os"
+	"time"
+)
+
+// Whisper files are read once for every week of blocks, like the TSMW option
+// reads them once per shard
+const tsdbPassDuration = 7 * 24 * time.Hour
+
+type tsdbBlock struct {
+	writer   *tsdb.BlockWriter
+	appender storage.Appender
+}
+
+// Write the whisper data as Prometheus TSDB blocks of tsdbBlockRange into
+// tsdbDir. The blocks can be moved into the data dir of a stopped Prometheus,
+// which picks them up on start. Series are named and labelled the same way
+// as with the PromRemoteWrite option
+func (migrationData *MigrationData) WriteTSDBBlocks() error {
+	if err := os.MkdirAll(migrationData.tsdbDir, 0755); err != nil {
+		return err
+	}
+	blockMs := int64(migrationData.tsdbBlockRange / time.Millisecond)
+	passMs := blockMs * int64(tsdbPassDuration/migrationData.tsdbBlockRange)
+	if passMs < blockMs {
+		passMs = blockMs
+	}
+	//blocks and passes are in the time after -time-shift
+	fromMs := migrationData.from.Add(migrationData.timeShift).Unix() * 1000
+	untilMs := migrationData.until.Add(migrationData.timeShift).Unix() * 1000
+
+	firstPass := fromMs - fromMs%blockMs
+	migrationData.progress.SetPasses(int((untilMs - firstPass + passMs - 1) / passMs))
+	for passStart := firstPass; passStart < untilMs; passStart = passStart + passMs {
+		passEnd := passStart + passMs
+		//the first pass starts at the block start, its points at -from
+		pointsStart := passStart
+		if pointsStart < fromMs {
+			pointsStart = fromMs
+		}
+		if err := migrationData.writeTSDBPass(pointsStart, passEnd, blockMs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write the blocks between passStart and passEnd, in milliseconds, from one
+// read of the whisper files
+func (migrationData *MigrationData) writeTSDBPass(passStart int64, passEnd int64,
+	blockMs int64) error {
+
+	ctx := context.Background()
+	blocks := make(map[int64]*tsdbBlock)
+	defer func() {
+		for _, block := range blocks {
+			block.writer.Close()
+		}
+	}()
+
+	//whisper returns the points after from, start a second early to include
+	//a point at exactly passStart
+	from := time.Unix(passStart/1000-1, 0).Add(-migrationData.timeShift)
+	if from.Before(migrationData.from) {
+		from = migrationData.from
+	}
+	until := time.Unix(passEnd/1000, 0).Add(-migrationData.timeShift)
+	if until.After(migrationData.until) {
+		until = migrationData.until
+	}
+	err := migrationData.FetchAllPoints(from, until,
+		func(wspFile string, wspPoints []whisper.Point) error {
+			//keep the points of this pass only before the transforms, a point
+			//of the next pass would be transformed twice otherwise. A resampled
+			//point is kept by the pass its bucket starts in
+			var passPoints []whisper.Point
+			shift := int64(migrationData.timeShift / time.Millisecond)
+			resample := migrationData.ResampleOf(wspFile)
+			for _, wspPoint := range wspPoints {
+				timestamp := wspPoint.Timestamp
+				if resample != nil {
+					timestamp = resample.Bucket(timestamp)
+				}
+				t := int64(timestamp)*1000 + shift
+				if t >= passStart && t < passEnd {
+					passPoints = append(passPoints, wspPoint)
+				}
+			}
+			if len(passPoints) == 0 {
+				return nil
+			}
+			mtf, wspPoints := migrationData.MapPoints(wspFile, passPoints)
+			var lset []labels.Label
+			for _, label := range PromLabels(mtf) {
+				lset = append(lset, labels.Label{Name: label.Name, Value: label.Value})
+			}
+			series := labels.New(lset...)
+
+			start := time.Now()
+			touched := make(map[int64]*tsdbBlock)
+			for _, wspPoint := range wspPoints {
+				t := int64(wspPoint.Timestamp) * 1000
+				if math.IsNaN(wspPoint.Value) {
+					continue
+				}
+				blockStart := t - t%blockMs
+				block := blocks[blockStart]
+				if block == nil {
+					//The writer is told the block is twice as long, so that the
+					//head accepts samples from the whole block range in any
+					//order of series, as promtool does for backfilling
+					writer, err := tsdb.NewBlockWriter(kitlog.NewNopLogger(),
+						migrationData.tsdbDir, 2*blockMs)
+					if err != nil {
+						return err
+					}
+					block = &tsdbBlock{writer: writer, appender: writer.Appender(ctx)}
+					blocks[blockStart] = block
+				}
+				if _, err := block.appender.Append(0, series, t, wspPoint.Value); err != nil {
+					return fmt.Errorf("%s: %s", wspFile, err)
+				}
+				touched[blockStart] = block
+			}
+			//commit per series to keep the appenders small
+			for _, block := range touched {
+				if err := block.appender.Commit(); err != nil {
+					return err
+				}
+				block.appender = block.writer.Appender(ctx)
+			}
+			migrationData.progress.PointsWritten(len(wspPoints))
+			ObserveWrite("TSDB", start, len(wspPoints))
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	for blockStart, block := range blocks {
+		id, err := block.writer.Flush(ctx)
+		if err != nil {
+			return fmt.Errorf("Error in writing block %s : %s",
+				time.Unix(blockStart/1000, 0).UTC(), err)
+		}
+		level.Info(logger).Log("msg", "TSDB block written", "phase", "write",
+			"block", id, "from", time.Unix(blockStart/1000, 0).UTC(),
+			"until", time.Unix((blockStart+blockMs)/1000, 0).UTC())
+	}
+	migrationData.report.AllWritten()
+	return nil
+}
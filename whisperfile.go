@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+	"io"
+	"math"
+	"time"
+)
+
+// Sizes of the on-disk whisper structures, all values are big endian
+//
+//	Metadata    : aggregationMethod, maxRetention, xFilesFactor, archiveCount
+//	ArchiveInfo : offset, secondsPerPoint, points
+//	Point       : timestamp, value
+const (
+	metadataSize    = 16
+	archiveInfoSize = 12
+	pointSize       = 12
+)
+
+type WhisperArchive struct {
+	Offset          uint32
+	SecondsPerPoint uint32
+	Points          uint32
+}
+
+type WhisperHeader struct {
+	AggregationMethod uint32
+	MaxRetention      uint32
+	XFilesFactor      float32
+	Archives          []WhisperArchive
+}
+
+func (archive WhisperArchive) Retention() uint32 {
+	return archive.SecondsPerPoint * archive.Points
+}
+
+func (archive WhisperArchive) Size() int64 {
+	return int64(archive.Points) * pointSize
+}
+
+// Reads the whisper header from r without going through the whisper package,
+// so that a corrupt file can be reported instead of crashing the reader
+func ReadWhisperHeader(r io.ReaderAt) (*WhisperHeader, error) {
+	buf := make([]byte, metadataSize)
+	if _, err := r.ReadAt(buf, 0); err != nil {
+		return nil, fmt.Errorf("truncated metadata: %s", err)
+	}
+	header := &WhisperHeader{
+		AggregationMethod: binary.BigEndian.Uint32(buf[0:4]),
+		MaxRetention:      binary.BigEndian.Uint32(buf[4:8]),
+		XFilesFactor:      math.Float32frombits(binary.BigEndian.Uint32(buf[8:12])),
+	}
+	archiveCount := binary.BigEndian.Uint32(buf[12:16])
+	if archiveCount == 0 {
+		return nil, fmt.Errorf("no archives in header")
+	}
+	//A real whisper file never has this many archives, a bigger count is
+	//garbage and would make us allocate a huge buffer
+	if archiveCount > 1024 {
+		return nil, fmt.Errorf("impossible archive count %d", archiveCount)
+	}
+
+	buf = make([]byte, int(archiveCount)*archiveInfoSize)
+	if _, err := r.ReadAt(buf, metadataSize); err != nil {
+		return nil, fmt.Errorf("truncated archive headers: %s", err)
+	}
+	header.Archives = make([]WhisperArchive, archiveCount)
+	for i := range header.Archives {
+		b := buf[i*archiveInfoSize:]
+		header.Archives[i] = WhisperArchive{
+			Offset:          binary.BigEndian.Uint32(b[0:4]),
+			SecondsPerPoint: binary.BigEndian.Uint32(b[4:8]),
+			Points:          binary.BigEndian.Uint32(b[8:12]),
+		}
+	}
+	return header, nil
+}
+
+// Checks that the header is consistent with itself and with the size of the
+// file it was read from. The rules follow whisper.py validateArchiveList
+func (header *WhisperHeader) Validate(fileSize int64) error {
+	if header.AggregationMethod < 1 || header.AggregationMethod > 8 {
+		return fmt.Errorf("unknown aggregation method %d", header.AggregationMethod)
+	}
+	if math.IsNaN(float64(header.XFilesFactor)) ||
+		header.XFilesFactor < 0 || header.XFilesFactor > 1 {
+		return fmt.Errorf("impossible xFilesFactor %v", header.XFilesFactor)
+	}
+	headerSize := int64(metadataSize + len(header.Archives)*archiveInfoSize)
+	var prev WhisperArchive
+	for i, archive := range header.Archives {
+		if archive.SecondsPerPoint == 0 {
+			return fmt.Errorf("archive %d has a zero interval", i)
+		}
+		if archive.Points == 0 {
+			return fmt.Errorf("archive %d has no points", i)
+		}
+		if int64(archive.Offset) < headerSize {
+			return fmt.Errorf("archive %d offset %d overlaps the header", i,
+				archive.Offset)
+		}
+		end := int64(archive.Offset) + archive.Size()
+		if end > fileSize {
+			return fmt.Errorf("archive %d ends at %d, past the end of file %d", i,
+				end, fileSize)
+		}
+		if i > 0 {
+			if archive.SecondsPerPoint <= prev.SecondsPerPoint ||
+				archive.SecondsPerPoint%prev.SecondsPerPoint != 0 {
+				return fmt.Errorf("archive %d interval %ds is impossible after %ds", i,
+					archive.SecondsPerPoint, prev.SecondsPerPoint)
+			}
+			if archive.Retention() <= prev.Retention() {
+				return fmt.Errorf("archive %d retention %ds is not longer than %ds", i,
+					archive.Retention(), prev.Retention())
+			}
+			if int64(archive.Offset) < int64(prev.Offset)+prev.Size() {
+				return fmt.Errorf("archive %d overlaps archive %d", i, i-1)
+			}
+		}
+		prev = archive
+	}
+	if header.MaxRetention != prev.Retention() {
+		return fmt.Errorf("max retention %ds does not match archives %ds",
+			header.MaxRetention, prev.Retention())
+	}
+	return nil
+}
+
+// Validates the header of a whisper file against its size, the returned error
+// is the reason the file can not be migrated
+func ValidateWhisperData(r io.ReaderAt, size int64) (*WhisperHeader, error) {
+	header, err := ReadWhisperHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	return header, header.Validate(size)
+}
+
+// Timestamp of the newest non-null point in the file. Archives are scanned
+// from the highest precision down, a lower archive is only read when every
+// point of the ones before it is empty. Returns 0 for a file never written to
+func (header *WhisperHeader) LastUpdate(r io.ReaderAt) (uint32, error) {
+	var last uint32
+	for _, archive := range header.Archives {
+		buf := make([]byte, archive.Size())
+		if _, err := r.ReadAt(buf, int64(archive.Offset)); err != nil {
+			return 0, err
+		}
+		for i := 0; i < len(buf); i = i + pointSize {
+			timestamp := binary.BigEndian.Uint32(buf[i : i+4])
+			value := math.Float64frombits(binary.BigEndian.Uint64(buf[i+4 : i+12]))
+			if timestamp > last && !math.IsNaN(value) {
+				last = timestamp
+			}
+		}
+		if last != 0 {
+			break
+		}
+	}
+	return last, nil
+}
+
+// Fetch the points between from and until the same way whisper.FetchUntilTime
+// does: the highest precision archive covering from is used and slots which
+// were not written for the requested interval are left out. Used for whisper
+// files which are not on disk, e.g. entries of a tar archive
+func (header *WhisperHeader) FetchPoints(r io.ReaderAt, from time.Time,
+	until time.Time, now time.Time) ([]whisper.Point, error) {
+
+	fromTime, untilTime, nowTime := from.Unix(), until.Unix(), now.Unix()
+	if oldest := nowTime - int64(header.MaxRetention); fromTime < oldest {
+		fromTime = oldest
+	}
+	if untilTime > nowTime {
+		untilTime = nowTime
+	}
+	if fromTime >= untilTime {
+		return nil, nil
+	}
+
+	archive := header.Archives[len(header.Archives)-1]
+	for _, a := range header.Archives {
+		if int64(a.Retention()) >= nowTime-fromTime {
+			archive = a
+			break
+		}
+	}
+	buf := make([]byte, archive.Size())
+	if _, err := r.ReadAt(buf, int64(archive.Offset)); err != nil {
+		return nil, err
+	}
+	baseInterval := int64(binary.BigEndian.Uint32(buf[0:4]))
+	if baseInterval == 0 { //nothing written to this archive yet
+		return nil, nil
+	}
+
+	step := int64(archive.SecondsPerPoint)
+	fromInterval := fromTime - fromTime%step + step
+	untilInterval := untilTime - untilTime%step + step
+	var points []whisper.Point
+	for interval := fromInterval; interval < untilInterval; interval = interval + step {
+		slot := ((interval - baseInterval) / step) % int64(archive.Points)
+		if slot < 0 {
+			slot = slot + int64(archive.Points)
+		}
+		b := buf[slot*pointSize:]
+		if int64(binary.BigEndian.Uint32(b[0:4])) != interval {
+			continue
+		}
+		points = append(points, whisper.Point{
+			Timestamp: uint32(interval),
+			Value:     math.Float64frombits(binary.BigEndian.Uint64(b[4:12])),
+		})
+	}
+	return points, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+)
+
+// The bytes of a whisper file with the given header and zeroed archives
+func whisperFileBytes(header WhisperHeader, archiveCount uint32) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, header.AggregationMethod)
+	binary.Write(&buf, binary.BigEndian, header.MaxRetention)
+	binary.Write(&buf, binary.BigEndian, math.Float32bits(header.XFilesFactor))
+	binary.Write(&buf, binary.BigEndian, archiveCount)
+	end := int64(buf.Len())
+	for _, archive := range header.Archives {
+		binary.Write(&buf, binary.BigEndian, archive)
+		if archiveEnd := int64(archive.Offset) + archive.Size(); archiveEnd > end {
+			end = archiveEnd
+		}
+	}
+	if end > int64(buf.Len()) {
+		buf.Write(make([]byte, end-int64(buf.Len())))
+	}
+	return buf.Bytes()
+}
+
+// 1m:1h,5m:1d
+func validWhisperHeader() WhisperHeader {
+	return WhisperHeader{AggregationMethod: 1, MaxRetention: 86400, XFilesFactor: 0.5,
+		Archives: []WhisperArchive{
+			{Offset: 40, SecondsPerPoint: 60, Points: 60},
+			{Offset: 40 + 60*pointSize, SecondsPerPoint: 300, Points: 288},
+		}}
+}
+
+func TestReadWhisperHeader(t *testing.T) {
+	valid := validWhisperHeader()
+	data := whisperFileBytes(valid, 2)
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"valid", data, ""},
+		{"truncated metadata", data[:10], "truncated metadata"},
+		{"no archives", whisperFileBytes(WhisperHeader{AggregationMethod: 1}, 0), "no archives"},
+		{"garbage archive count", whisperFileBytes(valid, 5000), "impossible archive count"},
+		{"truncated archive headers", data[:30], "truncated archive headers"},
+	}
+	for _, test := range tests {
+		header, err := ReadWhisperHeader(bytes.NewReader(test.data))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: err %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if header.AggregationMethod != 1 || header.MaxRetention != 86400 ||
+			header.XFilesFactor != 0.5 || len(header.Archives) != 2 ||
+			header.Archives[1] != valid.Archives[1] {
+			t.Errorf("%s: header %+v", test.name, header)
+		}
+	}
+}
+
+func TestWhisperHeaderValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(header *WhisperHeader)
+		size   int64
+		err    string
+	}{
+		{"valid", func(header *WhisperHeader) {}, 0, ""},
+		{"aggregation method", func(header *WhisperHeader) { header.AggregationMethod = 9 }, 0,
+			"unknown aggregation method"},
+		{"xFilesFactor", func(header *WhisperHeader) { header.XFilesFactor = 1.5 }, 0,
+			"impossible xFilesFactor"},
+		{"NaN xFilesFactor", func(header *WhisperHeader) {
+			header.XFilesFactor = float32(math.NaN())
+		}, 0, "impossible xFilesFactor"},
+		{"zero interval", func(header *WhisperHeader) { header.Archives[0].SecondsPerPoint = 0 }, 0,
+			"zero interval"},
+		{"no points", func(header *WhisperHeader) { header.Archives[1].Points = 0 }, 0,
+			"has no points"},
+		{"offset in header", func(header *WhisperHeader) { header.Archives[0].Offset = 20 }, 0,
+			"overlaps the header"},
+		{"truncated file", func(header *WhisperHeader) {}, 1000, "past the end of file"},
+		{"interval not a multiple", func(header *WhisperHeader) {
+			header.Archives[1].SecondsPerPoint = 90
+		}, 0, "impossible after"},
+		{"retention not longer", func(header *WhisperHeader) {
+			header.Archives[1].Points = 12
+			header.MaxRetention = 3600
+		}, 0, "not longer"},
+		{"archives overlap", func(header *WhisperHeader) { header.Archives[1].Offset = 100 }, 0,
+			"overlaps archive 0"},
+		{"max retention", func(header *WhisperHeader) { header.MaxRetention = 3600 }, 0,
+			"does not match"},
+	}
+	for _, test := range tests {
+		header := validWhisperHeader()
+		header.Archives = append([]WhisperArchive(nil), header.Archives...)
+		test.change(&header)
+		size := test.size
+		if size == 0 {
+			size = 40 + 348*pointSize
+		}
+		err := header.Validate(size)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: %s", test.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: err %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestValidateWhisperData(t *testing.T) {
+	data := whisperFileBytes(validWhisperHeader(), 2)
+	if _, err := ValidateWhisperData(bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	truncated := data[:len(data)-pointSize]
+	if _, err := ValidateWhisperData(bytes.NewReader(truncated), int64(len(truncated))); err == nil {
+		t.Fatal("truncated whisper file validated")
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Aggregation methods of whisper, the index is the value stored in the header
+var whisperAggregationMethods = []string{"", "average", "sum", "last", "max",
+	"min", "avg_zero", "absmax", "absmin"}
+
+func ParseAggregationMethod(name string) (uint32, error) {
+	for i, method := range whisperAggregationMethods {
+		if i > 0 && method == strings.ToLower(name) {
+			return uint32(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown aggregation method %s", name)
+}
+
+// Parse an archive schema in the storage-schemas.conf format, e.g.
+// 10s:1d,1m:30d,1h:5y. The precision and retention take the units of
+// ParseDuration, a retention without unit is a number of points and a
+// precision without unit a number of seconds
+func ParseArchiveSchema(schema string, aggregationMethod uint32,
+	xFilesFactor float32) (*WhisperHeader, error) {
+
+	header := &WhisperHeader{AggregationMethod: aggregationMethod,
+		XFilesFactor: xFilesFactor}
+	for _, def := range strings.Split(schema, ",") {
+		parts := strings.Split(strings.TrimSpace(def), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid archive %q, want precision:retention", def)
+		}
+		precision, err := parseSchemaSeconds(parts[0])
+		if err != nil || precision == 0 {
+			return nil, fmt.Errorf("invalid precision %q", parts[0])
+		}
+		var points uint64
+		if n, err := strconv.ParseUint(parts[1], 10, 32); err == nil {
+			points = n
+		} else {
+			retention, err := parseSchemaSeconds(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid retention %q", parts[1])
+			}
+			points = retention / precision
+		}
+		if points == 0 || points*precision > math.MaxUint32 {
+			return nil, fmt.Errorf("impossible retention %q", parts[1])
+		}
+		header.Archives = append(header.Archives, WhisperArchive{
+			SecondsPerPoint: uint32(precision), Points: uint32(points)})
+	}
+
+	offset := int64(metadataSize + len(header.Archives)*archiveInfoSize)
+	for i := range header.Archives {
+		header.Archives[i].Offset = uint32(offset)
+		offset = offset + header.Archives[i].Size()
+	}
+	header.MaxRetention = header.Archives[len(header.Archives)-1].Retention()
+	if err := header.Validate(offset); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+func parseSchemaSeconds(s string) (uint64, error) {
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return n, nil
+	}
+	d, err := ParseDuration(s)
+	if err != nil || d < 0 || d%time.Second != 0 {
+		return 0, fmt.Errorf("invalid duration %s", s)
+	}
+	return uint64(d / time.Second), nil
+}
+
+// A point of the source data with the interval it was stored at
+type sourcePoint struct {
+	whisper.Point
+	step uint32
+}
+
+// Write every whisper file into a new whisper file under whisperDir, at the
+// same path relative to wspPath, with the archives of whisperSchema. Every
+// archive is built by aggregating the best precision source data available
+// for its time range
+func (migrationData *MigrationData) WriteWhisperFiles() error {
+	now := time.Now()
+	if migrationData.source == "ceres" {
+		for _, node := range migrationData.wspFiles {
+			if err := migrationData.Canceled(); err != nil {
+				return err
+			}
+			if migrationData.IsQuarantined(node) {
+				continue
+			}
+			points, err := FetchCeresPoints(node, migrationData.from, migrationData.until)
+			migrationData.FileRead(migrationData.wspFileSizes[node])
+			if err != nil {
+				migrationData.Quarantine(node, err)
+				continue
+			}
+			pointsRead.Add(float64(len(points)))
+			//ceres slices do not tell the step of a point, assume the finest
+			//one of the new file so that no bucket is left out by xFilesFactor
+			var source []sourcePoint
+			for _, point := range points {
+				source = append(source, sourcePoint{point,
+					migrationData.whisperSchema.Archives[0].SecondsPerPoint})
+			}
+			start := time.Now()
+			if err := migrationData.writeWhisperFile(node+".wsp", source, now); err != nil {
+				return err
+			}
+			migrationData.progress.PointsWritten(len(source))
+			ObserveWrite("Whisper", start, len(source))
+			migrationData.report.FileMapped(node, "", len(source), len(source))
+			migrationData.report.Written(node)
+		}
+		return nil
+	}
+	return migrationData.EachWhisperFile(func(wspFile string, r io.ReaderAt, size int64) error {
+		source, err := FetchAllArchives(r, migrationData.from, migrationData.until, now)
+		migrationData.FileRead(size)
+		if err != nil {
+			migrationData.Quarantine(wspFile, err)
+			return nil
+		}
+		pointsRead.Add(float64(len(source)))
+		start := time.Now()
+		if err := migrationData.writeWhisperFile(wspFile, source, now); err != nil {
+			return err
+		}
+		migrationData.progress.PointsWritten(len(source))
+		ObserveWrite("Whisper", start, len(source))
+		migrationData.report.FileMapped(wspFile, "", len(source), len(source))
+		migrationData.report.Written(wspFile)
+		return nil
+	})
+}
+
+// The Whisper option copies the points of every archive as they are, the
+// transforms and resampling of a tag config can not be applied to them
+func (migrationData *MigrationData) CheckWhisperTagConfig() error {
+	for _, tagConfig := range migrationData.tagConfigs {
+		if len(tagConfig.Transforms) > 0 || tagConfig.Resample != nil {
+			return fmt.Errorf("Transforms and resample of pattern %s can not be used with the Whisper option",
+				tagConfig.Pattern)
+		}
+	}
+	return nil
+}
+
+// Fetch the points of every archive between from and until. A lower archive
+// only adds the points older than the range of the archives before it
+func FetchAllArchives(r io.ReaderAt, from time.Time, until time.Time,
+	now time.Time) ([]sourcePoint, error) {
+
+	header, err := ReadWhisperHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	var source []sourcePoint
+	covered := until.Unix()
+	for _, archive := range header.Archives {
+		archiveFrom := now.Add(-time.Duration(archive.Retention()) * time.Second)
+		if archiveFrom.Before(from) {
+			archiveFrom = from
+		}
+		if archiveFrom.Unix() >= covered {
+			continue
+		}
+		points, err := header.FetchPoints(r, archiveFrom, time.Unix(covered, 0), now)
+		if err != nil {
+			return nil, err
+		}
+		for _, point := range points {
+			if int64(point.Timestamp) < covered {
+				source = append(source, sourcePoint{point, archive.SecondsPerPoint})
+			}
+		}
+		covered = archiveFrom.Unix()
+	}
+	sort.Slice(source, func(i, j int) bool {
+		return source[i].Timestamp < source[j].Timestamp
+	})
+	return source, nil
+}
+
+// Show where every whisper file is written to and with which archives
+func (migrationData *MigrationData) PreviewWhisperFiles() {
+	var archives []string
+	for _, archive := range migrationData.whisperSchema.Archives {
+		archives = append(archives, fmt.Sprintf("%ds:%d", archive.SecondsPerPoint,
+			archive.Points))
+	}
+	fmt.Println("\nNew Whisper Archives->", strings.Join(archives, ","),
+		whisperAggregationMethods[migrationData.whisperSchema.AggregationMethod])
+	for _, wspFile := range migrationData.wspFiles {
+		outFile := migrationData.WhisperOutputPath(wspFile)
+		if migrationData.source == "ceres" {
+			outFile = outFile + ".wsp"
+		}
+		fmt.Println("\nWhisper File", wspFile, "\nNew Whisper File->", outFile)
+	}
+}
+
+// Path of the new whisper file for a source file
+func (migrationData *MigrationData) WhisperOutputPath(wspFile string) string {
+	rel := wspFile
+	if r, err := filepath.Rel(migrationData.wspPath, wspFile); err == nil &&
+		!strings.HasPrefix(r, "..") && migrationData.wspArchive == "" {
+		rel = r
+	}
+	rel = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(rel)), "/")
+	return filepath.Join(migrationData.whisperDir, filepath.FromSlash(rel))
+}
+
+// Write the new whisper file through a temporary file, which the file
+// discovery skips, so that an interrupted migration leaves no partial file
+func (migrationData *MigrationData) writeWhisperFile(wspFile string,
+	source []sourcePoint, now time.Time) error {
+
+	outFile := migrationData.WhisperOutputPath(wspFile)
+	if err := os.MkdirAll(filepath.Dir(outFile), 0755); err != nil {
+		return err
+	}
+	data := migrationData.whisperSchema.Build(source, now)
+	tmpFile := outFile + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+		return fmt.Errorf("Error in writing %s : %s", outFile, err)
+	}
+	return os.Rename(tmpFile, outFile)
+}
+
+// Create the contents of a whisper file with this header holding the source
+// points. Every archive holds the buckets of its range up to now, a bucket
+// is written when at least xFilesFactor of the source points it covers are
+// known
+func (header *WhisperHeader) Build(source []sourcePoint, now time.Time) []byte {
+	last := header.Archives[len(header.Archives)-1]
+	data := make([]byte, int64(last.Offset)+last.Size())
+	binary.BigEndian.PutUint32(data[0:4], header.AggregationMethod)
+	binary.BigEndian.PutUint32(data[4:8], header.MaxRetention)
+	binary.BigEndian.PutUint32(data[8:12], math.Float32bits(header.XFilesFactor))
+	binary.BigEndian.PutUint32(data[12:16], uint32(len(header.Archives)))
+	for i, archive := range header.Archives {
+		b := data[metadataSize+i*archiveInfoSize:]
+		binary.BigEndian.PutUint32(b[0:4], archive.Offset)
+		binary.BigEndian.PutUint32(b[4:8], archive.SecondsPerPoint)
+		binary.BigEndian.PutUint32(b[8:12], archive.Points)
+	}
+
+	nowTime := now.Unix()
+	for _, archive := range header.Archives {
+		step := int64(archive.SecondsPerPoint)
+		oldest := nowTime - int64(archive.Retention())
+		buf := data[archive.Offset:]
+		var baseInterval int64
+		for i := 0; i < len(source); {
+			interval := int64(source[i].Timestamp) - int64(source[i].Timestamp)%step
+			j := i
+			for j < len(source) && int64(source[j].Timestamp) < interval+step {
+				j++
+			}
+			bucket := source[i:j]
+			i = j
+			if interval <= oldest || interval > nowTime {
+				continue
+			}
+			value, ok := header.aggregate(bucket, step)
+			if !ok {
+				continue
+			}
+			//the first bucket written goes to the first slot, like whisper
+			//does for the first update of an archive
+			if baseInterval == 0 {
+				baseInterval = interval
+			}
+			slot := ((interval - baseInterval) / step) % int64(archive.Points)
+			b := buf[slot*pointSize:]
+			binary.BigEndian.PutUint32(b[0:4], uint32(interval))
+			binary.BigEndian.PutUint64(b[4:12], math.Float64bits(value))
+		}
+	}
+	return data
+}
+
+// Aggregate the source points of one bucket of step seconds
+func (header *WhisperHeader) aggregate(bucket []sourcePoint, step int64) (float64, bool) {
+	var known []float64
+	expected := 0.0
+	for _, point := range bucket {
+		if math.IsNaN(point.Value) {
+			continue
+		}
+		known = append(known, point.Value)
+		if int64(point.step) < step {
+			expected = math.Max(expected, float64(step)/float64(point.step))
+		}
+	}
+	if len(known) == 0 {
+		return 0, false
+	}
+	if expected < 1 {
+		expected = 1
+	}
+	if float64(len(known))/expected < float64(header.XFilesFactor) {
+		return 0, false
+	}
+
+	value := known[0]
+	switch whisperAggregationMethods[header.AggregationMethod] {
+	case "average", "sum", "avg_zero":
+		value = 0
+		for _, v := range known {
+			value = value + v
+		}
+		switch whisperAggregationMethods[header.AggregationMethod] {
+		case "average":
+			value = value / float64(len(known))
+		case "avg_zero":
+			value = value / math.Max(expected, float64(len(known)))
+		}
+	case "last":
+		value = known[len(known)-1]
+	case "max":
+		for _, v := range known {
+			value = math.Max(value, v)
+		}
+	case "min":
+		for _, v := range known {
+			value = math.Min(value, v)
+		}
+	case "absmax":
+		for _, v := range known {
+			if math.Abs(v) > math.Abs(value) {
+				value = v
+			}
+		}
+	case "absmin":
+		for _, v := range known {
+			if math.Abs(v) < math.Abs(value) {
+				value = v
+			}
+		}
+	}
+	return value, true
+}
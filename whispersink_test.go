@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseArchiveSchema(t *testing.T) {
+	tests := []struct {
+		schema string
+		want   []WhisperArchive
+		err    string
+	}{
+		{"10s:1d,1m:30d,1h:5y", []WhisperArchive{
+			{Offset: 52, SecondsPerPoint: 10, Points: 8640},
+			{Offset: 52 + 8640*pointSize, SecondsPerPoint: 60, Points: 43200},
+			{Offset: 52 + (8640+43200)*pointSize, SecondsPerPoint: 3600, Points: 43800},
+		}, ""},
+		//a retention without unit is a number of points, a precision
+		//without unit a number of seconds
+		{"60:1440", []WhisperArchive{{Offset: 28, SecondsPerPoint: 60, Points: 1440}}, ""},
+		{" 1m:1h , 5m:1d", []WhisperArchive{
+			{Offset: 40, SecondsPerPoint: 60, Points: 60},
+			{Offset: 40 + 60*pointSize, SecondsPerPoint: 300, Points: 288},
+		}, ""},
+		{"1m", nil, "want precision:retention"},
+		{"0s:1d", nil, "invalid precision"},
+		{"1.5s:1d", nil, "invalid precision"},
+		{"1m:forever", nil, "invalid retention"},
+		{"1h:1m", nil, "impossible retention"},
+		{"1m:200y", nil, "impossible retention"},
+		{"1m:1d,90s:30d", nil, "impossible after"},
+		{"1m:30d,5m:1d", nil, "not longer"},
+	}
+	for _, test := range tests {
+		header, err := ParseArchiveSchema(test.schema, 1, 0.5)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("ParseArchiveSchema(%q) err %v, want %q", test.schema, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseArchiveSchema(%q): %s", test.schema, err)
+			continue
+		}
+		if len(header.Archives) != len(test.want) {
+			t.Errorf("ParseArchiveSchema(%q) = %+v", test.schema, header.Archives)
+			continue
+		}
+		for i, archive := range header.Archives {
+			if archive != test.want[i] {
+				t.Errorf("ParseArchiveSchema(%q) archive %d = %+v, want %+v", test.schema,
+					i, archive, test.want[i])
+			}
+		}
+		last := test.want[len(test.want)-1]
+		if header.MaxRetention != last.Retention() || header.AggregationMethod != 1 ||
+			header.XFilesFactor != 0.5 {
+			t.Errorf("ParseArchiveSchema(%q) header %+v", test.schema, header)
+		}
+	}
+}
+
+func TestCheckWhisperTagConfig(t *testing.T) {
+	tests := []struct {
+		tagConfig TagConfig
+		err       bool
+	}{
+		{TagConfig{Pattern: "carbon.", Field: "value", Type: "integer"}, false},
+		{TagConfig{Pattern: "carbon.", Field: "value",
+			Transforms: []Transform{{Type: "scale", Value: 8}}}, true},
+		{TagConfig{Pattern: "carbon.", Field: "value",
+			Resample: &Resample{Interval: "5m"}}, true},
+	}
+	for _, test := range tests {
+		migrationData := &MigrationData{tagConfigs: []TagConfig{test.tagConfig}}
+		if err := migrationData.CheckWhisperTagConfig(); (err != nil) != test.err {
+			t.Errorf("CheckWhisperTagConfig(%+v) err %v", test.tagConfig, err)
+		}
+	}
+}